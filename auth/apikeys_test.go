@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// apiKeyAccounts implements the parts of models.Account used to authenticate API keys.
+type apiKeyAccounts struct {
+	models.Account
+	keys    map[string]*models.APIKey // By key hash.
+	touched []string
+}
+
+func (a *apiKeyAccounts) GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	if k, ok := a.keys[keyHash]; ok {
+		return k, nil
+	}
+	return nil, &models.Error{Kind: models.ErrNotFound, Message: "API key not found"}
+}
+
+func (a *apiKeyAccounts) GetRolePermissions(ctx context.Context, role string) ([]string, error) {
+	return []string{PermissionPatientRead, PermissionPatientCreate}, nil
+}
+
+func (a *apiKeyAccounts) TouchAPIKey(ctx context.Context, id string) error {
+	a.touched = append(a.touched, id)
+	return nil
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, hash, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.Regexp(t, `^hpk_[0-9a-f]{8}$`, prefix)
+	assert.True(t, strings.HasPrefix(key, prefix+"_"))
+	assert.Equal(t, HashToken(key), hash)
+
+	other, _, _, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+}
+
+func TestAPIKeyPermissions(t *testing.T) {
+	role := []string{PermissionPatientRead, PermissionPatientCreate}
+
+	assert.Equal(t, []string{PermissionPatientRead}, APIKeyPermissions([]string{PermissionPatientRead}, role))
+	assert.Equal(t, []string{PermissionPatientRead}, APIKeyPermissions([]string{PermissionPatientRead, PermissionUserManage}, role))
+	assert.Empty(t, APIKeyPermissions([]string{PermissionUserManage}, role))
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	key, prefix, hash, err := GenerateAPIKey()
+	require.NoError(t, err)
+	apiKey := &models.APIKey{ID: "k1", ServiceAccountID: "sa-1", Prefix: prefix, KeyHash: hash, Role: "receptionist",
+		Scopes: []string{PermissionPatientRead, PermissionUserManage}}
+	accounts := &apiKeyAccounts{keys: map[string]*models.APIKey{hash: apiKey}}
+
+	app := fiber.New()
+	app.Get("/", JWTMiddleware(NewHMACKeyRing([]byte("test_secret_key_for_jwt")), accounts), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"user": c.Locals("userID"), "permissions": c.Locals("permissions")})
+	})
+
+	request := func(key string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(APIKeyHeader, key)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Valid keys authenticate as their service account with the scopes its role allows
+	resp := request(key)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "sa-1", body["user"])
+	assert.Equal(t, []interface{}{PermissionPatientRead}, body["permissions"])
+	assert.Equal(t, []string{"k1"}, accounts.touched)
+
+	// Unknown keys
+	assert.Equal(t, http.StatusUnauthorized, request(key+"x").StatusCode)
+
+	// Expired keys
+	apiKey.ExpiresAt = sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}
+	assert.Equal(t, http.StatusUnauthorized, request(key).StatusCode)
+	apiKey.ExpiresAt = sql.NullTime{}
+
+	// Revoked keys
+	apiKey.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	assert.Equal(t, http.StatusUnauthorized, request(key).StatusCode)
+	apiKey.RevokedAt = sql.NullTime{}
+
+	// Disabled service accounts
+	apiKey.Disabled = true
+	assert.Equal(t, http.StatusUnauthorized, request(key).StatusCode)
+}
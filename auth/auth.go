@@ -1,17 +1,32 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"log"
 	"strings"
 
+	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// JWTMiddleware is a Fiber middleware that authenticates requests using JWTs.
-// It expects a "Bearer <token>" in the Authorization header.
-func JWTMiddleware(c *fiber.Ctx) error {
+// JWTMiddleware creates a Fiber middleware that authenticates requests using JWTs.
+// It expects a "Bearer <token>" in the Authorization header, verifies it against the
+// key ring and rejects tokens whose ID (jti) or session (sid) has been revoked or whose account has been disabled.
+// Requests from service accounts carry an API key in the X-API-Key header instead.
+func JWTMiddleware(keys *KeyRing, accounts models.Account) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return authenticateJWT(c, keys, accounts)
+	}
+}
+
+// authenticateJWT validates the bearer token of the current request and stores its claims in c.Locals.
+func authenticateJWT(c *fiber.Ctx, keys *KeyRing, accounts models.Account) error {
+	if apiKey := c.Get(APIKeyHeader); apiKey != "" {
+		return authenticateAPIKey(c, accounts, apiKey)
+	}
+
 	authHeader := c.Get("Authorization")
 	if authHeader == "" {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing authentication token"})
@@ -26,7 +41,7 @@ func JWTMiddleware(c *fiber.Ctx) error {
 	tokenString := tokenParts[1]
 
 	// Parse and validate the JWT.
-	token, err := ParseJWT(tokenString)
+	token, err := ParseJWT(keys, tokenString)
 	if err != nil || !token.Valid {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired token"})
 	}
@@ -37,68 +52,110 @@ func JWTMiddleware(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to extract token claims"})
 	}
 
+	// MFA challenge tokens are signed with the same keys but only grant access to the second login step.
+	if claims["typ"] != tokenTypeAccess {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired token"})
+	}
+
 	// Get userID from claims.
 	userID, ok := claims["sub"].(string) // "sub" is a standard JWT claim for subject/user ID.
 	if !ok {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "User ID claim missing or invalid in token"})
 	}
 
-	// Get userRole from claims.
-	userRole, ok := claims["role"].(string)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "User role claim missing or invalid in token"})
+	// Get the token ID from claims and make sure it has not been revoked (e.g. by /logout).
+	tokenID, ok := claims["jti"].(string)
+	if !ok || tokenID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token ID claim missing or invalid in token"})
+	}
+
+	revoked, err := accounts.IsAccessTokenRevoked(c.UserContext(), tokenID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify token revocation status"})
+	}
+	if revoked {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token has been revoked"})
+	}
+
+	// Signing out, or an administrator terminating the session, ends every token of the session.
+	sessionID, ok := claims["sid"].(string)
+	if !ok || sessionID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Session ID claim missing or invalid in token"})
+	}
+
+	sessionActive, err := accounts.IsSessionActive(c.UserContext(), sessionID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify session status"})
+	}
+	if !sessionActive {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Session has ended"})
+	}
+
+	// The account is loaded on every request, so that disabling (or deleting) it or changing its role takes
+	// effect immediately, not when the token expires.
+	user, err := accounts.GetUserByID(c.UserContext(), userID)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify account status"})
+	}
+	if user == nil || user.Disabled {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Account is disabled"})
+	}
+
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Expiration claim missing or invalid in token"})
+	}
+
+	// Get how the user authenticated, used to enforce two-factor authentication.
+	authMethods, err := stringSliceClaim(claims, "amr")
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Authentication methods claim invalid in token"})
 	}
 
-	// Store userID and userRole in Fiber's locals for subsequent handlers.
+	// Store userID, the current role with its permissions and token metadata in Fiber's locals for subsequent handlers.
+	// The role and permissions claims of the token are only informational, as they may be out of date.
 	c.Locals("userID", userID)
-	c.Locals("userRole", userRole)
+	c.Locals("userRole", user.Role)
+	c.Locals("permissions", user.Permissions)
+	c.Locals("authMethods", authMethods)
+	c.Locals("mfaRequired", user.MFARequired)
+	c.Locals("tokenID", tokenID)
+	c.Locals("tokenExpiresAt", expiresAt.Time)
+	c.Locals("sessionID", sessionID)
+
+	// Failing to record the use must not fail the request.
+	if err := accounts.TouchSession(c.UserContext(), sessionID, c.IP()); err != nil {
+		log.Printf("Failed to record use of session %s: %v", sessionID, err)
+	}
 
 	return c.Next() // Continue to the next middleware or route handler.
 }
 
 // ParseJWT parses and validates a JWT string.
-// It uses the JWT_SECRET environment variable for signing key verification.
-func ParseJWT(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Ensure the token's signing method is HMAC.
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		// Return the secret key for validation.
-		jwtSecret := os.Getenv("JWT_SECRET")
-		if jwtSecret == "" {
-			return nil, fmt.Errorf("JWT_SECRET environment variable not set")
-		}
-		return []byte(jwtSecret), nil
-	})
-
-	return token, err
+// The verification key is looked up in the key ring by the token's "kid" header.
+func ParseJWT(keys *KeyRing, tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, keys.Keyfunc, jwt.WithIssuer(tokenIssuer))
 }
 
-// RoleMiddleware creates a Fiber middleware that restricts access based on user roles.
-// It expects userRole to be set in c.Locals by a preceding middleware (e.g., JWTMiddleware).
-func RoleMiddleware(allowedRoles ...string) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		userRole := c.Locals("userRole")
+// stringSliceClaim reads an optional claim holding a JSON array of strings.
+func stringSliceClaim(claims jwt.MapClaims, name string) ([]string, error) {
+	raw, ok := claims[name]
+	if !ok || raw == nil {
+		return nil, nil
+	}
 
-		if userRole == nil {
-			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied: User role not found in context"})
-		}
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("claim %q is not an array", name)
+	}
 
-		roleStr, ok := userRole.(string)
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		v, ok := item.(string)
 		if !ok {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Access denied: Invalid role format in context"})
-		}
-
-		// Check if the user's role is in the list of allowed roles.
-		for _, allowed := range allowedRoles {
-			if roleStr == allowed {
-				return c.Next() // User has an allowed role, continue.
-			}
+			return nil, fmt.Errorf("claim %q contains a non-string value", name)
 		}
-
-		// If no allowed role matches, deny access.
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied: Insufficient permissions for this action"})
+		values = append(values, v)
 	}
+	return values, nil
 }
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sessionAccounts implements the parts of models.Account used to authenticate access tokens.
+type sessionAccounts struct {
+	models.Account
+	active  map[string]bool // Active sessions by ID.
+	users   map[string]*models.User
+	touched []string
+}
+
+func (a *sessionAccounts) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return false, nil
+}
+
+func (a *sessionAccounts) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	if u, ok := a.users[id]; ok {
+		return u, nil
+	}
+	return nil, &models.Error{Kind: models.ErrNotFound, Message: "user not found"}
+}
+
+func (a *sessionAccounts) IsSessionActive(ctx context.Context, id string) (bool, error) {
+	return a.active[id], nil
+}
+
+func (a *sessionAccounts) TouchSession(ctx context.Context, id, ip string) error {
+	a.touched = append(a.touched, id)
+	return nil
+}
+
+func TestJWTMiddlewareEnforcesSessions(t *testing.T) {
+	keys := NewHMACKeyRing([]byte("test_secret_key_for_jwt"))
+	accounts := &sessionAccounts{active: map[string]bool{"session-1": true},
+		users: map[string]*models.User{"user-1": {ID: "user-1", Role: "doctor"}}}
+
+	app := fiber.New()
+	app.Get("/", JWTMiddleware(keys, accounts), func(c *fiber.Ctx) error {
+		return c.SendString(c.Locals("sessionID").(string))
+	})
+
+	request := func(sessionID string) *http.Response {
+		token, err := GenerateToken(keys, &models.User{ID: "user-1", Role: "doctor"}, sessionID, []string{AuthMethodPassword}, defaultAccessTokenTTL)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Tokens of an active session are accepted, and the session's use is recorded
+	assert.Equal(t, http.StatusOK, request("session-1").StatusCode)
+	assert.Equal(t, []string{"session-1"}, accounts.touched)
+
+	// Tokens of a session that was signed out or terminated stop working before they expire
+	accounts.active["session-1"] = false
+	assert.Equal(t, http.StatusUnauthorized, request("session-1").StatusCode)
+
+	// Tokens without a session
+	assert.Equal(t, http.StatusUnauthorized, request("").StatusCode)
+}
+
+func TestJWTMiddlewareUsesCurrentRole(t *testing.T) {
+	keys := NewHMACKeyRing([]byte("test_secret_key_for_jwt"))
+	doctor := &models.User{ID: "user-1", Role: "doctor", Permissions: []string{PermissionPatientRead, PermissionPatientDiagnose}}
+	accounts := &sessionAccounts{active: map[string]bool{"session-1": true}, users: map[string]*models.User{"user-1": doctor}}
+
+	app := fiber.New()
+	app.Put("/diagnosis", JWTMiddleware(keys, accounts), RequirePermission(PermissionPatientDiagnose), func(c *fiber.Ctx) error {
+		return c.SendString(c.Locals("userRole").(string))
+	})
+
+	token, err := GenerateToken(keys, doctor, "session-1", []string{AuthMethodPassword}, defaultAccessTokenTTL)
+	require.NoError(t, err)
+	request := func() *http.Response {
+		req := httptest.NewRequest(http.MethodPut, "/diagnosis", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+	assert.Equal(t, http.StatusOK, request().StatusCode)
+
+	// A token issued before the user was demoted still names the old role and permissions, but no longer grants them
+	accounts.users["user-1"] = &models.User{ID: "user-1", Role: "receptionist", Permissions: []string{PermissionPatientRead}}
+	assert.Equal(t, http.StatusForbidden, request().StatusCode)
+
+	// Disabled and deleted accounts lose access altogether
+	accounts.users["user-1"] = &models.User{ID: "user-1", Role: "doctor", Disabled: true}
+	assert.Equal(t, http.StatusUnauthorized, request().StatusCode)
+	delete(accounts.users, "user-1")
+	assert.Equal(t, http.StatusUnauthorized, request().StatusCode)
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// notOnCareTeamMessage is returned to callers acting on a patient outside their care teams.
+const notOnCareTeamMessage = "Access denied: Patient is not under your care"
+
+// RequireCareTeam creates a Fiber middleware for routes about the patient in the :id parameter that only lets
+// callers through who hold patient:read_all or are on that patient's current care team.
+// It belongs after the route's permission checks.
+func RequireCareTeam(patients models.Storage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		inScope, err := patientInScope(c, patients)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify care team membership"})
+		}
+		if !inScope {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": notOnCareTeamMessage})
+		}
+		return c.Next()
+	}
+}
+
+// patientInScope reports whether the caller may act on the patient in the :id parameter at all.
+func patientInScope(c *fiber.Ctx, patients models.Storage) (bool, error) {
+	if HasPermission(c, PermissionPatientReadAll) {
+		return true, nil
+	}
+	userID, _ := c.Locals("userID").(string)
+	if userID == "" {
+		return false, nil
+	}
+	return patients.IsOnCareTeam(c.UserContext(), userID, c.Params("id"))
+}
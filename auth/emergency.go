@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultEmergencyAccessTTL = time.Hour
+
+// emergencyAccessPermissions are the permissions an emergency access grant gives for its patient.
+var emergencyAccessPermissions = []string{PermissionPatientRead}
+
+// RequirePatientPermission is like RequirePermission for routes about the patient in the :id parameter.
+// Callers without patient:read_all must also be on the patient's current care team (see RequireCareTeam).
+// Callers lacking either may still get through with an active emergency access grant for that patient;
+// every request let through by a grant is recorded against it, and if that fails the request is refused.
+func RequirePatientPermission(patients models.Storage, accounts models.Account, permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := c.Locals("permissions").([]string); !ok {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied: No permissions found in context"})
+		}
+
+		denied := "Access denied: Insufficient permissions for this action"
+		if HasPermission(c, permission) {
+			inScope, err := patientInScope(c, patients)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify care team membership"})
+			}
+			if inScope {
+				return c.Next()
+			}
+			denied = notOnCareTeamMessage
+		}
+
+		userID, _ := c.Locals("userID").(string)
+		if userID == "" || !slices.Contains(emergencyAccessPermissions, permission) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": denied})
+		}
+
+		grant, err := accounts.GetActiveEmergencyAccess(c.UserContext(), userID, c.Params("id"))
+		if err != nil {
+			if errors.Is(err, models.ErrNotFound) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": denied})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify emergency access"})
+		}
+
+		if err := accounts.RecordEmergencyAccessUse(c.UserContext(), grant.ID, c.Method()+" "+c.Path()); err != nil {
+			log.Printf("Failed to record use of emergency access grant %s: %v", grant.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to record emergency access"})
+		}
+
+		c.Locals("emergencyAccessID", grant.ID)
+		return c.Next()
+	}
+}
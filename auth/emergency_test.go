@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// emergencyAccounts implements the parts of models.Account used to check emergency access grants.
+type emergencyAccounts struct {
+	models.Account
+	grants map[string]string // Active grant IDs by user and patient ID.
+	uses   []string
+}
+
+func (a *emergencyAccounts) GetActiveEmergencyAccess(ctx context.Context, userID, patientID string) (*models.EmergencyAccess, error) {
+	id, ok := a.grants[userID+"/"+patientID]
+	if !ok {
+		return nil, &models.Error{Kind: models.ErrNotFound, Message: "emergency access grant not found"}
+	}
+	return &models.EmergencyAccess{ID: id, UserID: userID, PatientID: patientID}, nil
+}
+
+func (a *emergencyAccounts) RecordEmergencyAccessUse(ctx context.Context, grantID, action string) error {
+	a.uses = append(a.uses, grantID+" "+action)
+	return nil
+}
+
+// careTeams implements the parts of models.Storage used to check care team membership.
+type careTeams struct {
+	models.Storage
+	members map[string]bool // Current care team memberships by user and patient ID.
+}
+
+func (p *careTeams) IsOnCareTeam(ctx context.Context, userID, patientID string) (bool, error) {
+	return p.members[userID+"/"+patientID], nil
+}
+
+func TestRequirePatientPermission(t *testing.T) {
+	accounts := &emergencyAccounts{grants: map[string]string{"nurse-1/p1": "grant-1", "doctor-1/p2": "grant-2"}}
+	patients := &careTeams{members: map[string]bool{"doctor-1/p1": true}}
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("userID", c.Get("X-User"))
+		c.Locals("permissions", strings.Split(c.Get("X-Permission"), ","))
+		return c.Next()
+	})
+	app.Get("/patients/:id", RequirePatientPermission(patients, accounts, PermissionPatientRead), func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
+	app.Put("/patients/:id", RequirePatientPermission(patients, accounts, PermissionPatientUpdate), func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
+
+	request := func(method, user, permission, patientID string) int {
+		req := httptest.NewRequest(method, "/patients/"+patientID, nil)
+		req.Header.Set("X-User", user)
+		req.Header.Set("X-Permission", permission)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	// The permission is enough for patients on the caller's care team, or any patient with patient:read_all
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "doctor-1", PermissionPatientRead, "p1"))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "receptionist-1", PermissionPatientRead+","+PermissionPatientReadAll, "p3"))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "doctor-1", PermissionPatientRead, "p3"))
+	assert.Empty(t, accounts.uses)
+
+	// Outside their care teams, clinicians can break the glass
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "doctor-1", PermissionPatientRead, "p2"))
+	assert.Equal(t, []string{"grant-2 GET /patients/p2"}, accounts.uses)
+	accounts.uses = nil
+
+	// A grant opens up reading its own patient only, and every use is recorded
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "nurse-1", PermissionUserRead, "p1"))
+	assert.Equal(t, []string{"grant-1 GET /patients/p1"}, accounts.uses)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "nurse-1", PermissionUserRead, "p2"))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "nurse-2", PermissionUserRead, "p1"))
+
+	// Grants never allow changes
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPut, "nurse-1", PermissionUserRead, "p1"))
+	assert.Len(t, accounts.uses, 1)
+}
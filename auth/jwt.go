@@ -1,48 +1,154 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"os"
 	"time"
 
 	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
 	jwt "github.com/golang-jwt/jwt/v5"
 )
 
-// GenerateToken creates a new JWT for the given user.
-// The token includes user ID, role, issuer, and expiration time.
-func GenerateToken(u *models.User) (string, error) {
-	// Retrieve the JWT secret from environment variables.
-	// This secret is crucial for signing the token and must be kept secure.
-	secret := os.Getenv("JWT_SECRET")
+const (
+	// tokenIssuer is the "iss" claim placed in every token issued by this service.
+	tokenIssuer = "Hospital-Portal"
 
-	// Debugging: Print the secret to ensure it's loaded correctly.
-	// In a production environment, you might want to remove or secure this debug output.
-	fmt.Printf("DEBUG: JWT_SECRET in GenerateToken is: '%s' (Length: %d)\n", secret, len(secret))
+	defaultAccessTokenTTL   = 15 * time.Minute
+	defaultRefreshTokenTTL  = 7 * 24 * time.Hour
+	defaultPasswordResetTTL = time.Hour
 
-	if secret == "" {
-		return "", fmt.Errorf("JWT_SECRET environment variable not set")
+	// mfaTokenTTL is how long a user has to complete the second login step.
+	mfaTokenTTL = 5 * time.Minute
+
+	// Values of the "typ" claim, so that an MFA challenge token can never be used as an access token.
+	tokenTypeAccess = "access"
+	tokenTypeMFA    = "mfa"
+)
+
+// Authentication method references (RFC 8176) recorded in the "amr" claim of access tokens.
+const (
+	AuthMethodPassword = "pwd" // The user presented their password.
+	AuthMethodOTP      = "otp" // The user presented a one-time code (TOTP or recovery code).
+	AuthMethodMFA      = "mfa" // The identity provider reported a login with multiple factors.
+	AuthMethodSSO      = "sso" // The user signed in through the OpenID Connect identity provider (not defined by RFC 8176).
+)
+
+// TokenLifetimes are how long the tokens and grants issued to users stay valid.
+type TokenLifetimes struct {
+	Access          time.Duration // Access tokens.
+	Refresh         time.Duration // Refresh tokens, and with them the sessions they belong to.
+	PasswordReset   time.Duration // Password reset links.
+	EmergencyAccess time.Duration // Emergency access grants.
+}
+
+// DefaultTokenLifetimes returns the lifetimes used unless configured otherwise.
+func DefaultTokenLifetimes() TokenLifetimes {
+	return TokenLifetimes{
+		Access:          defaultAccessTokenTTL,
+		Refresh:         defaultRefreshTokenTTL,
+		PasswordReset:   defaultPasswordResetTTL,
+		EmergencyAccess: defaultEmergencyAccessTTL,
 	}
+}
+
+// GenerateToken creates a new access token for the given user that expires after ttl, signed with the key ring's
+// active key. The token includes user ID, role, the role's permissions, how the user authenticated (amr),
+// the session it belongs to (sid), a unique token ID (jti), issuer, and expiration time.
+func GenerateToken(keys *KeyRing, u *models.User, sessionID string, authMethods []string, ttl time.Duration) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+
+	now := time.Now()
 
 	// Define the claims to be included in the JWT.
 	// These claims carry information about the user and the token itself.
-	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub":  u.ID,                                  // "sub" (subject) is a standard claim for the principal (user) of the JWT.
-		"role": u.Role,                                // Custom claim to store the user's role for authorization.
-		"iss":  "Hospital-Portal",                     // "iss" (issuer) identifies the principal that issued the JWT.
-		"exp":  time.Now().Add(time.Hour * 24).Unix(), // "exp" (expiration time) after which the JWT must not be accepted for processing (24 hours from now).
-		"iat":  time.Now().Unix(),                     // "iat" (issued at time) identifies the time at which the JWT was issued.
-	})
-
-	// For debugging: Print the claims before signing.
-	fmt.Printf("Token Claims added %+v\n", claims)
-
-	// Sign the token with the secret key.
-	// The secret converts to a byte slice as required by the signing method.
-	tokenString, err := claims.SignedString([]byte(secret))
+	claims := jwt.MapClaims{
+		"sub":     u.ID,                // "sub" (subject) is a standard claim for the principal (user) of the JWT.
+		"typ":     tokenTypeAccess,     // Custom claim distinguishing access tokens from MFA challenge tokens.
+		"role":    u.Role,              // Custom claim to store the user's role for display and auditing.
+		"perms":   u.Permissions,       // Custom claim with the permissions granted to the role at issue time, for clients; requests are authorized with the current role.
+		"amr":     authMethods,         // "amr" (authentication methods references) records how the user logged in.
+		"mfa_req": u.MFARequired,       // Custom claim set when the user's role requires a second factor.
+		"sid":     sessionID,           // "sid" (session ID) ties the token to the login it was issued for, so signing out ends it.
+		"jti":     jti,                 // "jti" (JWT ID) uniquely identifies the token so it can be revoked.
+		"iss":     tokenIssuer,         // "iss" (issuer) identifies the principal that issued the JWT.
+		"exp":     now.Add(ttl).Unix(), // "exp" (expiration time) after which the JWT must not be accepted for processing.
+		"iat":     now.Unix(),          // "iat" (issued at time) identifies the time at which the JWT was issued.
+	}
+
+	return keys.Sign(claims)
+}
+
+// GenerateMFAToken creates a short-lived challenge token returned by the first login step to users with TOTP enabled.
+// It only proves that the password was correct and is exchanged for an access token by the second step.
+func GenerateMFAToken(keys *KeyRing, u *models.User) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub": u.ID,
+		"typ": tokenTypeMFA,
+		"jti": jti,
+		"iss": tokenIssuer,
+		"exp": now.Add(mfaTokenTTL).Unix(),
+		"iat": now.Unix(),
+	}
+
+	return keys.Sign(claims)
+}
+
+// ParseMFAToken validates an MFA challenge token and returns the user it was issued to,
+// together with its ID and expiry so that the caller can revoke it once used.
+func ParseMFAToken(keys *KeyRing, tokenString string) (userID, tokenID string, expiresAt time.Time, err error) {
+	token, err := ParseJWT(keys, tokenString)
+	if err != nil || !token.Valid {
+		return "", "", time.Time{}, fmt.Errorf("invalid or expired MFA token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != tokenTypeMFA {
+		return "", "", time.Time{}, fmt.Errorf("invalid or expired MFA token")
+	}
+
+	userID, _ = claims["sub"].(string)
+	tokenID, _ = claims["jti"].(string)
+	exp, err := claims.GetExpirationTime()
+	if userID == "" || tokenID == "" || err != nil || exp == nil {
+		return "", "", time.Time{}, fmt.Errorf("invalid or expired MFA token")
+	}
+	return userID, tokenID, exp.Time, nil
+}
+
+// GenerateOpaqueToken creates a new random token, such as a refresh or invitation token.
+// It returns the token to hand to the client and the hash to persist; the plaintext is never stored.
+func GenerateOpaqueToken() (token string, hash string, err error) {
+	token, err = randomToken(32)
 	if err != nil {
-		return "", fmt.Errorf("failed to sign token: %w", err)
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
 	}
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex-encoded SHA-256 digest of an opaque token.
+// Opaque tokens carry enough entropy that a fast hash is sufficient for storage.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
 
-	return tokenString, nil
+// randomToken returns n cryptographically random bytes encoded as URL-safe base64.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultKeyGracePeriod is how long retired keys keep verifying tokens unless configured otherwise.
+const DefaultKeyGracePeriod = 24 * time.Hour
+
+// activatedSuffix names the file next to each <kid>.pem that holds the time (RFC 3339) the key started signing
+// tokens. It is kept apart from the key file's modification time, which copying, touching or restoring the
+// file changes without anyone rotating keys.
+const activatedSuffix = ".activated"
+
+// SigningKey is a single key in a KeyRing, identified by its key ID (kid).
+type SigningKey struct {
+	ID          string            // Key ID placed in the "kid" header of tokens signed with this key.
+	Method      jwt.SigningMethod // Signing algorithm (RS256, ES256, ... or HS256 for shared secrets).
+	ActivatedAt time.Time         // Time the key started signing tokens, read from <kid>.activated; the newest key signs by default.
+
+	signKey   interface{}      // *rsa.PrivateKey, *ecdsa.PrivateKey or []byte.
+	verifyKey interface{}      // *rsa.PublicKey, *ecdsa.PublicKey or []byte.
+	notAfter  time.Time        // Zero for current keys; end of the grace period for retired keys.
+	public    crypto.PublicKey // Nil for symmetric keys, which are never published.
+}
+
+// KeyRing holds the keys used to sign and verify tokens.
+// Exactly one key signs new tokens; every other key in the ring still verifies
+// tokens until its grace period ends, so rotating keys does not log anyone out.
+type KeyRing struct {
+	mu     sync.RWMutex
+	active *SigningKey
+	keys   map[string]*SigningKey
+
+	// Source settings, kept so that the ring can be reloaded after a key rotation.
+	dir       string
+	activeKID string
+	grace     time.Duration
+}
+
+// NewHMACKeyRing creates a key ring with a single HS256 shared secret.
+// It exists for development setups and for tokens issued before asymmetric keys were configured.
+func NewHMACKeyRing(secret []byte) *KeyRing {
+	key := &SigningKey{
+		ID:        "hs256",
+		Method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+	return &KeyRing{
+		active: key,
+		keys:   map[string]*SigningKey{key.ID: key},
+	}
+}
+
+// LoadKeyRing loads every PEM-encoded private key ("<kid>.pem") from dir, each with its activation time
+// ("<kid>.activated"). The key named activeKID signs new tokens; when activeKID is empty the most recently
+// activated key is used. Keys activated before the active key keep verifying tokens for the grace period
+// measured from the moment the active key was activated.
+func LoadKeyRing(dir, activeKID string, grace time.Duration) (*KeyRing, error) {
+	kr := &KeyRing{dir: dir, activeKID: activeKID, grace: grace}
+	if err := kr.Reload(); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// Reload re-reads the key directory, picking up newly added or removed keys.
+// It is a no-op for rings that were not loaded from disk.
+func (kr *KeyRing) Reload() error {
+	if kr.dir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(kr.dir, "*.pem"))
+	if err != nil {
+		return fmt.Errorf("failed to list signing keys: %w", err)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no signing keys (*.pem) found in %s", kr.dir)
+	}
+
+	keys := make(map[string]*SigningKey, len(paths))
+	var ordered []*SigningKey
+	for _, path := range paths {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			return err
+		}
+		keys[key.ID] = key
+		ordered = append(ordered, key)
+	}
+
+	// Newest first, with the kid as a stable tie-breaker.
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].ActivatedAt.Equal(ordered[j].ActivatedAt) {
+			return ordered[i].ID > ordered[j].ID
+		}
+		return ordered[i].ActivatedAt.After(ordered[j].ActivatedAt)
+	})
+
+	active := ordered[0]
+	if kr.activeKID != "" {
+		var ok bool
+		if active, ok = keys[kr.activeKID]; !ok {
+			return fmt.Errorf("active signing key %q not found in %s", kr.activeKID, kr.dir)
+		}
+	}
+
+	// Keys older than the active one are retired and only accepted during the grace period.
+	for _, key := range ordered {
+		if key != active && key.ActivatedAt.Before(active.ActivatedAt) {
+			key.notAfter = active.ActivatedAt.Add(kr.grace)
+		}
+	}
+
+	kr.mu.Lock()
+	kr.active = active
+	kr.keys = keys
+	kr.mu.Unlock()
+	return nil
+}
+
+// ActiveKeyID returns the ID of the key that currently signs new tokens.
+func (kr *KeyRing) ActiveKeyID() string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.active.ID
+}
+
+// Sign signs the claims with the active key and sets the "kid" header.
+func (kr *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	kr.mu.RLock()
+	key := kr.active
+	kr.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	signed, err := token.SignedString(key.signKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// Keyfunc returns the verification key for a parsed token, for use with jwt.Parse.
+// The key is selected by the token's "kid" header and must match the token's algorithm.
+func (kr *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := kr.keys[kid]
+	if !ok {
+		// Tokens issued before key IDs were introduced carry no kid; only a shared secret can verify them.
+		if kid != "" || kr.active.public != nil {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		key = kr.active
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	if !key.notAfter.IsZero() && time.Now().After(key.notAfter) {
+		return nil, fmt.Errorf("signing key %q has been retired", key.ID)
+	}
+	return key.verifyKey, nil
+}
+
+// JWK is the JSON Web Key representation of a public signing key (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set document as served from /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys that can currently verify tokens.
+// Symmetric keys and retired keys past their grace period are never included.
+func (kr *KeyRing) JWKS() JWKSet {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	now := time.Now()
+	for _, key := range kr.keys {
+		if key.public == nil || (!key.notAfter.IsZero() && now.After(key.notAfter)) {
+			continue
+		}
+
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = pub.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// loadSigningKey reads a PEM-encoded RSA or ECDSA private key and its activation time; the file name
+// (without extension) is its kid.
+func loadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key %s: %w", path, err)
+	}
+	activatedAt, err := readActivationTime(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", path)
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("signing key %s has unsupported PEM type %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", path, err)
+	}
+
+	key := &SigningKey{
+		ID:          strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		ActivatedAt: activatedAt,
+		signKey:     private,
+	}
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.verifyKey = &k.PublicKey
+		key.public = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			key.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			key.Method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("signing key %s uses an unsupported curve", path)
+		}
+		key.verifyKey = &k.PublicKey
+		key.public = &k.PublicKey
+	default:
+		return nil, fmt.Errorf("signing key %s must be an RSA or ECDSA key", path)
+	}
+
+	return key, nil
+}
+
+// readActivationTime reads the activation time of the key stored at path from its .activated file.
+func readActivationTime(path string) (time.Time, error) {
+	activatedPath := strings.TrimSuffix(path, filepath.Ext(path)) + activatedSuffix
+	data, err := os.ReadFile(activatedPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read the activation time of signing key %s (write the time it starts signing, "+
+			"e.g. %s, to %s): %w", path, time.Now().UTC().Format(time.RFC3339), activatedPath, err)
+	}
+	activatedAt, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid activation time in %s, expected RFC 3339: %w", activatedPath, err)
+	}
+	return activatedAt, nil
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeRSAKey writes a new RSA private key to dir/<kid>.pem, activated at the given time.
+func writeRSAKey(t *testing.T, dir, kid string, activatedAt time.Time) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writePEM(t, dir, kid, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), activatedAt)
+}
+
+// writeECKey writes a new P-256 private key to dir/<kid>.pem, activated at the given time.
+func writeECKey(t *testing.T, dir, kid string, activatedAt time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	writePEM(t, dir, kid, "PRIVATE KEY", der, activatedAt)
+}
+
+func writePEM(t *testing.T, dir, kid, blockType string, der []byte, activatedAt time.Time) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, kid+".activated"), []byte(activatedAt.Format(time.RFC3339)+"\n"), 0o600))
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "user-1", "iss": tokenIssuer, "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func TestKeyRingRotationWithinGracePeriod(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeRSAKey(t, dir, "2024-old", now.Add(-2*time.Hour))
+
+	kr, err := LoadKeyRing(dir, "", 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-old", kr.ActiveKeyID())
+
+	oldToken, err := kr.Sign(testClaims())
+	require.NoError(t, err)
+
+	// Rotate: add a newer EC key and reload.
+	writeECKey(t, dir, "2024-new", now.Add(-time.Hour))
+	require.NoError(t, kr.Reload())
+	assert.Equal(t, "2024-new", kr.ActiveKeyID())
+
+	newToken, err := kr.Sign(testClaims())
+	require.NoError(t, err)
+
+	parsed, err := ParseJWT(kr, newToken)
+	require.NoError(t, err)
+	assert.Equal(t, "ES256", parsed.Method.Alg())
+	assert.Equal(t, "2024-new", parsed.Header["kid"])
+
+	// Tokens signed by the previous key still validate during the grace period.
+	_, err = ParseJWT(kr, oldToken)
+	assert.NoError(t, err)
+
+	jwks := kr.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "2024-new", jwks.Keys[0].Kid)
+	assert.Equal(t, "EC", jwks.Keys[0].Kty)
+	assert.Equal(t, "P-256", jwks.Keys[0].Crv)
+	assert.Equal(t, "2024-old", jwks.Keys[1].Kid)
+	assert.Equal(t, "RSA", jwks.Keys[1].Kty)
+	assert.Equal(t, "AQAB", jwks.Keys[1].E)
+}
+
+func TestKeyRingRetiredKeyAfterGracePeriod(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeRSAKey(t, dir, "old", now.Add(-72*time.Hour))
+
+	oldRing, err := LoadKeyRing(dir, "", 24*time.Hour)
+	require.NoError(t, err)
+	oldToken, err := oldRing.Sign(testClaims())
+	require.NoError(t, err)
+
+	// The new key has been active for longer than the grace period.
+	writeRSAKey(t, dir, "new", now.Add(-48*time.Hour))
+	kr, err := LoadKeyRing(dir, "", 24*time.Hour)
+	require.NoError(t, err)
+
+	_, err = ParseJWT(kr, oldToken)
+	assert.Error(t, err)
+
+	jwks := kr.JWKS()
+	require.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "new", jwks.Keys[0].Kid)
+}
+
+func TestKeyRingExplicitActiveKey(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeRSAKey(t, dir, "a", now.Add(-2*time.Hour))
+	writeRSAKey(t, dir, "b", now.Add(-time.Hour))
+
+	kr, err := LoadKeyRing(dir, "a", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "a", kr.ActiveKeyID())
+
+	_, err = LoadKeyRing(dir, "missing", time.Hour)
+	assert.Error(t, err)
+
+	_, err = LoadKeyRing(t.TempDir(), "", time.Hour)
+	assert.Error(t, err)
+}
+
+func TestKeyRingIgnoresModificationTime(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeRSAKey(t, dir, "old", now.Add(-72*time.Hour))
+	writeRSAKey(t, dir, "new", now.Add(-48*time.Hour))
+
+	// Copying, touching or restoring a key file does not make it the active key or restart a grace period
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "old.pem"), now, now))
+	kr, err := LoadKeyRing(dir, "", 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "new", kr.ActiveKeyID())
+	require.Len(t, kr.JWKS().Keys, 1)
+
+	// Keys without an activation time are refused
+	require.NoError(t, os.Remove(filepath.Join(dir, "old.activated")))
+	_, err = LoadKeyRing(dir, "", 24*time.Hour)
+	assert.ErrorContains(t, err, "old.activated")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old.activated"), []byte("yesterday"), 0o600))
+	_, err = LoadKeyRing(dir, "", 24*time.Hour)
+	assert.ErrorContains(t, err, "expected RFC 3339")
+}
+
+func TestKeyRingRejectsAlgorithmMismatch(t *testing.T) {
+	dir := t.TempDir()
+	writeRSAKey(t, dir, "rsa", time.Now())
+	kr, err := LoadKeyRing(dir, "", time.Hour)
+	require.NoError(t, err)
+
+	// An HS256 token claiming to be signed by the RSA key must not verify.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = "rsa"
+	forgedString, err := forged.SignedString([]byte("anything"))
+	require.NoError(t, err)
+
+	_, err = ParseJWT(kr, forgedString)
+	assert.Error(t, err)
+
+	// Tokens without a kid are only accepted by shared-secret rings.
+	unkeyed := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	unkeyedString, err := unkeyed.SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	_, err = ParseJWT(kr, unkeyedString)
+	assert.Error(t, err)
+
+	_, err = ParseJWT(NewHMACKeyRing([]byte("secret")), unkeyedString)
+	assert.NoError(t, err)
+	assert.Empty(t, NewHMACKeyRing([]byte("secret")).JWKS().Keys)
+}
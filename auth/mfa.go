@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// HasAuthMethod reports whether the authenticated caller logged in using the given method (e.g. AuthMethodOTP).
+// It expects authentication methods to be set in c.Locals by a preceding middleware (e.g., JWTMiddleware).
+func HasAuthMethod(c *fiber.Ctx, method string) bool {
+	methods, _ := c.Locals("authMethods").([]string)
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireMFA is a Fiber middleware that rejects callers whose role requires two-factor authentication
+// but who logged in without a second factor, e.g. because they have not enrolled yet.
+// A multi-factor login reported by the single sign-on identity provider counts as a second factor.
+func RequireMFA(c *fiber.Ctx) error {
+	if required, _ := c.Locals("mfaRequired").(bool); required && !HasAuthMethod(c, AuthMethodOTP) && !HasAuthMethod(c, AuthMethodMFA) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Two-factor authentication is required for your role. Enrol at /api/me/mfa/totp and log in again."})
+	}
+	return c.Next()
+}
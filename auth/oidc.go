@@ -0,0 +1,400 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// OIDCLoginStateTTL is how long a user has to complete a single sign-on login at the identity provider.
+	OIDCLoginStateTTL = 10 * time.Minute
+
+	// jwksRefreshInterval limits how often the provider's keys are re-fetched when a token names an unknown key.
+	jwksRefreshInterval = time.Minute
+
+	// maxOIDCResponseSize bounds the responses read from the identity provider.
+	maxOIDCResponseSize = 1 << 20
+)
+
+// OIDCConfig describes the OpenID Connect identity provider used for single sign-on.
+type OIDCConfig struct {
+	Issuer       string      // Issuer URL; the discovery document is fetched from <Issuer>/.well-known/openid-configuration.
+	ClientID     string      // Client ID registered at the identity provider.
+	ClientSecret string      // Client secret; may be empty for public clients, which rely on PKCE alone.
+	RedirectURL  string      // Callback URL registered at the identity provider, e.g. https://portal.example/login/oidc/callback.
+	Scopes       []string    // Scopes requested in addition to "openid".
+	GroupsClaim  string      // ID token claim listing the user's groups.
+	RoleMapping  []GroupRole // Group to role mapping; the first group the user belongs to decides the role.
+}
+
+// GroupRole maps a group of the identity provider to a role of this service.
+type GroupRole struct {
+	Group string
+	Role  string
+}
+
+// ParseRoleMapping parses a comma-separated list of group=role pairs, keeping their order.
+func ParseRoleMapping(s string) ([]GroupRole, error) {
+	var mapping []GroupRole
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" || role == "" {
+			return nil, fmt.Errorf("invalid role mapping %q, expected group=role", pair)
+		}
+		mapping = append(mapping, GroupRole{Group: group, Role: role})
+	}
+	return mapping, nil
+}
+
+// RoleForGroups returns the role of the first mapping whose group is among groups.
+func (cfg *OIDCConfig) RoleForGroups(groups []string) (string, bool) {
+	member := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		member[g] = true
+	}
+	for _, m := range cfg.RoleMapping {
+		if member[m.Group] {
+			return m.Role, true
+		}
+	}
+	return "", false
+}
+
+// OIDCClaims are the verified claims of an ID token that the service uses.
+type OIDCClaims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Groups        []string
+	AuthMethods   []string // The identity provider's "amr" claim, if any.
+}
+
+// SessionAuthMethods returns the authentication methods recorded for a single sign-on login.
+// A second factor at the identity provider is recorded as AuthMethodMFA, so RequireMFA accepts the login.
+func (c *OIDCClaims) SessionAuthMethods() []string {
+	methods := []string{AuthMethodSSO}
+	for _, m := range c.AuthMethods {
+		if m == "mfa" || m == "otp" || m == "hwk" {
+			return append(methods, AuthMethodMFA)
+		}
+	}
+	return methods
+}
+
+// OIDCProvider signs users in with an OpenID Connect identity provider using the
+// authorization code flow with PKCE (RFC 7636).
+type OIDCProvider struct {
+	Config OIDCConfig
+
+	client                *http.Client
+	authorizationEndpoint string
+	tokenEndpoint         string
+	jwksURI               string
+
+	mu            sync.RWMutex
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// NewOIDCProvider fetches the provider's discovery document and signing keys.
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig, client *http.Client) (*OIDCProvider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	discoveryURL := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, client, discoveryURL, &discovery); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	if discovery.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("OIDC discovery document is for issuer %q, expected %q", discovery.Issuer, cfg.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document lacks an authorization, token or JWKS endpoint")
+	}
+
+	p := &OIDCProvider{
+		Config:                cfg,
+		client:                client,
+		authorizationEndpoint: discovery.AuthorizationEndpoint,
+		tokenEndpoint:         discovery.TokenEndpoint,
+		jwksURI:               discovery.JWKSURI,
+	}
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GeneratePKCE returns a new PKCE code verifier and its S256 code challenge.
+func GeneratePKCE() (verifier, challenge string, err error) {
+	verifier, err = randomToken(32)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate PKCE verifier: %w", err)
+	}
+	return verifier, PKCEChallenge(verifier), nil
+}
+
+// PKCEChallenge derives the S256 code challenge of a code verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the identity provider URL the user is sent to for signing in.
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Config.ClientID},
+		"redirect_uri":          {p.Config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.Config.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.authorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.authorizationEndpoint + sep + q.Encode()
+}
+
+// Exchange redeems an authorization code at the token endpoint and returns the verified claims of the ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*OIDCClaims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"client_id":     {p.Config.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponseSize)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode token response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request rejected: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response contains no ID token")
+	}
+
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of an ID token and returns its claims.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*OIDCClaims, error) {
+	keyfunc := func(token *jwt.Token) (interface{}, error) { return p.keyfunc(ctx, token) }
+	token, err := jwt.Parse(rawIDToken, keyfunc,
+		jwt.WithIssuer(p.Config.Issuer),
+		jwt.WithAudience(p.Config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256"}),
+	)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid ID token claims")
+	}
+	if claims["nonce"] != nonce {
+		return nil, fmt.Errorf("ID token nonce does not match")
+	}
+
+	// With several audiences, the token must have been issued to us (OIDC Core 3.1.3.7).
+	if aud, _ := claims.GetAudience(); len(aud) > 1 && claims["azp"] != p.Config.ClientID {
+		return nil, fmt.Errorf("ID token was issued to another client")
+	}
+
+	sub, _ := claims.GetSubject()
+	if sub == "" {
+		return nil, fmt.Errorf("ID token has no subject")
+	}
+
+	groups, err := stringSliceClaim(claims, p.Config.GroupsClaim)
+	if err != nil {
+		return nil, err
+	}
+	authMethods, err := stringSliceClaim(claims, "amr")
+	if err != nil {
+		return nil, err
+	}
+
+	out := &OIDCClaims{
+		Issuer:      p.Config.Issuer,
+		Subject:     sub,
+		Groups:      groups,
+		AuthMethods: authMethods,
+	}
+	out.Email, _ = claims["email"].(string)
+	out.EmailVerified, _ = claims["email_verified"].(bool)
+	out.Name, _ = claims["name"].(string)
+	return out, nil
+}
+
+// keyfunc returns the provider key that signed a token, re-fetching the provider's keys
+// when the token names a key that is not known yet (i.e. the provider rotated its keys).
+func (p *OIDCProvider) keyfunc(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.RLock()
+	key, ok := p.keys[kid]
+	stale := time.Since(p.keysFetchedAt) > jwksRefreshInterval
+	p.mu.RUnlock()
+
+	if !ok && stale {
+		if err := p.refreshKeys(ctx); err != nil {
+			return nil, err
+		}
+		p.mu.RLock()
+		key, ok = p.keys[kid]
+		p.mu.RUnlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// refreshKeys fetches the provider's JSON Web Key Set.
+func (p *OIDCProvider) refreshKeys(ctx context.Context) error {
+	var set JWKSet
+	if err := getJSON(ctx, p.client, p.jwksURI, &set); err != nil {
+		return fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue // Skip key types we cannot use rather than failing on all keys.
+		}
+		keys[jwk.Kid] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+// PublicKey decodes an RSA or EC public key from its JWK representation.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// getJSON fetches url and decodes its JSON body into v.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponseSize)).Decode(v)
+}
@@ -0,0 +1,148 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	auth "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/auth"
+	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/auth/oidctest"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestProvider starts a mock identity provider and a provider configured for it.
+func newTestProvider(t *testing.T) (*oidctest.Server, *auth.OIDCProvider) {
+	idp := oidctest.NewServer("hospital-api", "client-secret")
+	t.Cleanup(idp.Close)
+
+	provider, err := auth.NewOIDCProvider(context.Background(), auth.OIDCConfig{
+		Issuer:       idp.Issuer(),
+		ClientID:     "hospital-api",
+		ClientSecret: "client-secret",
+		RedirectURL:  "http://localhost:3000/login/oidc/callback",
+		GroupsClaim:  "groups",
+	}, nil)
+	require.NoError(t, err)
+	return idp, provider
+}
+
+func TestOIDCExchange(t *testing.T) {
+	idp, provider := newTestProvider(t)
+	idp.SetUser(oidctest.User{Subject: "idp-42", Email: "dr.house@example.com", EmailVerified: true, Name: "Gregory House",
+		Groups: []string{"ward-doctors"}, AuthMethods: []string{"pwd", "otp"}})
+
+	verifier, challenge, err := auth.GeneratePKCE()
+	require.NoError(t, err)
+	code, state, err := idp.Authorize(provider.AuthCodeURL("state-1", "nonce-1", challenge))
+	require.NoError(t, err)
+	assert.Equal(t, "state-1", state)
+
+	claims, err := provider.Exchange(context.Background(), code, verifier, "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, idp.Issuer(), claims.Issuer)
+	assert.Equal(t, "idp-42", claims.Subject)
+	assert.Equal(t, "dr.house@example.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+	assert.Equal(t, []string{"ward-doctors"}, claims.Groups)
+	assert.Equal(t, []string{auth.AuthMethodSSO, auth.AuthMethodMFA}, claims.SessionAuthMethods())
+
+	// Codes are single use
+	_, err = provider.Exchange(context.Background(), code, verifier, "nonce-1")
+	assert.Error(t, err)
+}
+
+func TestOIDCExchangeRejectsMismatches(t *testing.T) {
+	idp, provider := newTestProvider(t)
+	idp.SetUser(oidctest.User{Subject: "idp-42", Email: "dr.house@example.com"})
+
+	authorize := func() (code, verifier string) {
+		verifier, challenge, err := auth.GeneratePKCE()
+		require.NoError(t, err)
+		code, _, err = idp.Authorize(provider.AuthCodeURL("state", "nonce", challenge))
+		require.NoError(t, err)
+		return code, verifier
+	}
+
+	// A different PKCE verifier, e.g. an intercepted code redeemed by someone else
+	code, _ := authorize()
+	other, _, err := auth.GeneratePKCE()
+	require.NoError(t, err)
+	_, err = provider.Exchange(context.Background(), code, other, "nonce")
+	assert.Error(t, err)
+
+	// A different nonce, e.g. a replayed ID token
+	code, verifier := authorize()
+	_, err = provider.Exchange(context.Background(), code, verifier, "another-nonce")
+	assert.Error(t, err)
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	idp, provider := newTestProvider(t)
+
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{"iss": idp.Issuer(), "sub": "idp-42", "aud": "hospital-api", "nonce": "n", "exp": time.Now().Add(time.Minute).Unix()}
+	}
+	verify := func(c jwt.MapClaims) error {
+		raw, err := idp.SignIDToken(c)
+		require.NoError(t, err)
+		_, err = provider.VerifyIDToken(context.Background(), raw, "n")
+		return err
+	}
+
+	assert.NoError(t, verify(claims()))
+
+	c := claims()
+	c["aud"] = "another-client"
+	assert.Error(t, verify(c), "wrong audience")
+
+	c = claims()
+	c["aud"] = []string{"hospital-api", "another-client"}
+	assert.Error(t, verify(c), "several audiences without azp")
+	c["azp"] = "hospital-api"
+	assert.NoError(t, verify(c))
+
+	c = claims()
+	c["iss"] = "https://evil.example"
+	assert.Error(t, verify(c), "wrong issuer")
+
+	c = claims()
+	c["exp"] = time.Now().Add(-time.Minute).Unix()
+	assert.Error(t, verify(c), "expired")
+
+	c = claims()
+	delete(c, "exp")
+	assert.Error(t, verify(c), "no expiry")
+
+	c = claims()
+	delete(c, "sub")
+	assert.Error(t, verify(c), "no subject")
+
+	// Tokens signed with a key the provider does not publish
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims()).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = provider.VerifyIDToken(context.Background(), hmac, "n")
+	assert.Error(t, err)
+}
+
+func TestOIDCRoleMapping(t *testing.T) {
+	mapping, err := auth.ParseRoleMapping(" ward-doctors=doctor , front-desk=receptionist,")
+	require.NoError(t, err)
+	assert.Equal(t, []auth.GroupRole{{Group: "ward-doctors", Role: "doctor"}, {Group: "front-desk", Role: "receptionist"}}, mapping)
+
+	_, err = auth.ParseRoleMapping("ward-doctors")
+	assert.Error(t, err)
+	_, err = auth.ParseRoleMapping("=doctor")
+	assert.Error(t, err)
+
+	cfg := &auth.OIDCConfig{RoleMapping: mapping}
+
+	// The first mapping wins, regardless of the order of the user's groups
+	role, ok := cfg.RoleForGroups([]string{"front-desk", "ward-doctors"})
+	assert.True(t, ok)
+	assert.Equal(t, "doctor", role)
+
+	_, ok = cfg.RoleForGroups([]string{"visitors"})
+	assert.False(t, ok)
+}
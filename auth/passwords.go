@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// PasswordPolicy decides which passwords users may choose. It is enforced wherever a password is set,
+// not at login, so existing passwords keep working after the policy is tightened.
+type PasswordPolicy struct {
+	MinLength int                 // Minimum length in characters.
+	MaxLength int                 // Maximum length in bytes; bcrypt ignores everything after the 72nd byte.
+	DenyList  map[string]struct{} // Lower-cased passwords known from breaches, which must not be used.
+}
+
+// DefaultPasswordPolicy returns the policy used unless configured otherwise. Its deny list is empty.
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength: 8,
+		MaxLength: 72,
+	}
+}
+
+// LoadPasswordDenyList reads a list of forbidden passwords, one per line, such as a breached-password corpus.
+// Blank lines and lines starting with # are skipped. Entries are matched case-insensitively.
+func LoadPasswordDenyList(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open password deny list: %w", err)
+	}
+	defer f.Close()
+
+	denyList := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		denyList[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read password deny list: %w", err)
+	}
+	return denyList, nil
+}
+
+// Validate checks a new password of the account with the given email against the policy.
+// The returned error is meant to be shown to the user.
+func (p PasswordPolicy) Validate(password, email string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		return fmt.Errorf("password must be at most %d bytes long", p.MaxLength)
+	}
+
+	lower := strings.ToLower(password)
+	if email != "" {
+		email = strings.ToLower(strings.TrimSpace(email))
+		local, _, _ := strings.Cut(email, "@")
+		if lower == email || lower == local {
+			return fmt.Errorf("password must not be the same as the email address")
+		}
+	}
+	if _, denied := p.DenyList[lower]; denied {
+		return fmt.Errorf("password is too common or has appeared in a data breach; choose a different one")
+	}
+	return nil
+}
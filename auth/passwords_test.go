@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	p := DefaultPasswordPolicy()
+	p.DenyList = map[string]struct{}{"password123": {}}
+
+	assert.NoError(t, p.Validate("correct horse battery", "alice@example.com"))
+
+	// Length limits
+	assert.Error(t, p.Validate("", "alice@example.com"))
+	assert.Error(t, p.Validate("short", "alice@example.com"))
+	assert.Error(t, p.Validate(strings.Repeat("a", 73), "alice@example.com"))
+	assert.NoError(t, p.Validate("pässwörd", "alice@example.com"), "length counts characters, not bytes")
+
+	// Deny list, case-insensitive
+	assert.Error(t, p.Validate("password123", "alice@example.com"))
+	assert.Error(t, p.Validate("PassWord123", "alice@example.com"))
+
+	// Not the email address or its local part
+	assert.Error(t, p.Validate("Alice@Example.com", "alice@example.com"))
+	assert.Error(t, p.Validate("alexandra", "alexandra@example.com"))
+}
+
+func TestLoadPasswordDenyList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# breached passwords\n123456789\n\n  Qwertyuiop  \n"), 0o600))
+
+	denyList, err := LoadPasswordDenyList(path)
+	require.NoError(t, err)
+	assert.Len(t, denyList, 2)
+	assert.Contains(t, denyList, "123456789")
+	assert.Contains(t, denyList, "qwertyuiop")
+
+	_, err = LoadPasswordDenyList(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// Permissions understood by the API. Roles are mapped to sets of these in the role_permissions table.
+const (
+	PermissionPatientRead     = "patient:read"     // List and view patient records; limited to the caller's care teams without patient:read_all.
+	PermissionPatientReadAll  = "patient:read_all" // Lift the care team limit: act on every patient record.
+	PermissionPatientCreate   = "patient:create"   // Register new patients.
+	PermissionPatientUpdate   = "patient:update"   // Change patient demographics (name, age, gender).
+	PermissionPatientDiagnose = "patient:diagnose" // Set or change a patient's diagnosis.
+	PermissionPatientDelete   = "patient:delete"   // Delete patient records.
+	PermissionPatientExport   = "patient:export"   // Export patient records (e.g. as CSV).
+
+	// PermissionPatientManageDeleted lets a user list deleted patient records and restore them.
+	PermissionPatientManageDeleted = "patient:manage_deleted"
+
+	// PermissionPatientEmergencyAccess lets a user "break the glass": obtain time-boxed access to a single
+	// patient record outside their normal permissions by stating a reason.
+	PermissionPatientEmergencyAccess = "patient:emergency_access"
+
+	PermissionUserRead   = "user:read"   // List and view user accounts.
+	PermissionUserManage = "user:manage" // Create, invite, change the role of, disable and delete user accounts.
+
+	PermissionCareTeamManage = "careteam:manage" // Assign clinicians to patient care teams and end assignments.
+
+	PermissionAuditRead = "audit:read" // Review audit trails such as the emergency access report.
+
+	PermissionSystemRead = "system:read" // View the operational state of the service, such as database pool statistics.
+)
+
+// HasPermission reports whether the authenticated caller holds the given permission.
+// It expects permissions to be set in c.Locals by a preceding middleware (e.g., JWTMiddleware).
+func HasPermission(c *fiber.Ctx, permission string) bool {
+	permissions, _ := c.Locals("permissions").([]string)
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission creates a Fiber middleware that only lets callers through when they hold every listed permission.
+func RequirePermission(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := c.Locals("permissions").([]string); !ok {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied: No permissions found in context"})
+		}
+
+		for _, p := range permissions {
+			if !HasPermission(c, p) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied: Insufficient permissions for this action"})
+			}
+		}
+		return c.Next()
+	}
+}
+
+// RequireAnyPermission creates a Fiber middleware that lets callers through when they hold at least one of the listed permissions.
+// It suits routes whose handler decides what the caller may do based on which of them is held.
+func RequireAnyPermission(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := c.Locals("permissions").([]string); !ok {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied: No permissions found in context"})
+		}
+
+		for _, p := range permissions {
+			if HasPermission(c, p) {
+				return c.Next()
+			}
+		}
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied: Insufficient permissions for this action"})
+	}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+)
+
+// Prefixes of login throttle keys. Each key is throttled independently.
+const (
+	throttleAccountPrefix = "account:"
+	throttleIPPrefix      = "ip:"
+	throttleMFAPrefix     = "mfa:"
+)
+
+// AccountThrottleKey returns the throttle key for login attempts against an email address.
+// It does not matter whether an account with that email exists.
+func AccountThrottleKey(email string) string {
+	return throttleAccountPrefix + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPThrottleKey returns the throttle key for login attempts from a client IP address.
+func IPThrottleKey(ip string) string {
+	return throttleIPPrefix + ip
+}
+
+// MFAThrottleKey returns the throttle key for second-factor attempts of a user.
+func MFAThrottleKey(userID string) string {
+	return throttleMFAPrefix + userID
+}
+
+// LoginThrottlePolicy decides how failed login attempts are slowed down and when a key is locked out.
+type LoginThrottlePolicy struct {
+	MaxAccountFailures int           // Failures before an account (or a user's second factor) is locked.
+	MaxIPFailures      int           // Failures before a client IP is locked; higher since IPs can be shared.
+	FreeFailures       int           // Failures allowed before attempts are delayed.
+	BaseDelay          time.Duration // Delay after the first delayed failure; doubles with every further failure.
+	MaxDelay           time.Duration // Upper bound for the delay between attempts.
+	LockoutDuration    time.Duration // How long a key stays locked.
+	FailureWindow      time.Duration // Failures older than this are forgotten.
+}
+
+// DefaultLoginThrottlePolicy returns the policy used unless configured otherwise.
+func DefaultLoginThrottlePolicy() LoginThrottlePolicy {
+	return LoginThrottlePolicy{
+		MaxAccountFailures: 5,
+		MaxIPFailures:      50,
+		FreeFailures:       2,
+		BaseDelay:          time.Second,
+		MaxDelay:           30 * time.Second,
+		LockoutDuration:    15 * time.Minute,
+		FailureWindow:      15 * time.Minute,
+	}
+}
+
+// RetryAfter returns how long the caller has to wait before another attempt for this key is allowed,
+// or zero if an attempt is allowed now.
+func (p LoginThrottlePolicy) RetryAfter(t *models.LoginThrottle, now time.Time) time.Duration {
+	if t.LockedUntil.Valid && t.LockedUntil.Time.After(now) {
+		return t.LockedUntil.Time.Sub(now)
+	}
+	if now.Sub(t.LastFailureAt) > p.FailureWindow {
+		return 0
+	}
+	if wait := t.LastFailureAt.Add(p.Delay(t.Failures)).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Delay returns the minimum time between attempts after the given number of consecutive failures.
+func (p LoginThrottlePolicy) Delay(failures int) time.Duration {
+	if failures <= p.FreeFailures {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeFailures + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// ShouldLock reports whether the key of t has failed often enough to be locked out.
+func (p LoginThrottlePolicy) ShouldLock(t *models.LoginThrottle) bool {
+	max := p.maxFailures(t.Key)
+	return max > 0 && t.Failures >= max
+}
+
+// OverLimit reports whether t counts more attempts than its key is allowed before being locked out.
+// Such attempts raced the one reaching the limit and are refused without checking their credentials.
+func (p LoginThrottlePolicy) OverLimit(t *models.LoginThrottle) bool {
+	max := p.maxFailures(t.Key)
+	return max > 0 && t.Failures > max
+}
+
+// maxFailures returns the number of failures after which key is locked out, or zero for no limit.
+func (p LoginThrottlePolicy) maxFailures(key string) int {
+	if strings.HasPrefix(key, throttleIPPrefix) {
+		return p.MaxIPFailures
+	}
+	return p.MaxAccountFailures
+}
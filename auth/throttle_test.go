@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginThrottlePolicyDelays(t *testing.T) {
+	p := DefaultLoginThrottlePolicy()
+
+	assert.Equal(t, time.Duration(0), p.Delay(1))
+	assert.Equal(t, time.Duration(0), p.Delay(2))
+	assert.Equal(t, time.Second, p.Delay(3))
+	assert.Equal(t, 2*time.Second, p.Delay(4))
+	assert.Equal(t, 4*time.Second, p.Delay(5))
+	assert.Equal(t, p.MaxDelay, p.Delay(100))
+}
+
+func TestLoginThrottlePolicyRetryAfter(t *testing.T) {
+	p := DefaultLoginThrottlePolicy()
+	now := time.Now()
+
+	// No failures yet
+	assert.Zero(t, p.RetryAfter(&models.LoginThrottle{Key: AccountThrottleKey("a@example.com")}, now))
+
+	// Within the progressive delay
+	recent := &models.LoginThrottle{Key: AccountThrottleKey("a@example.com"), Failures: 4, LastFailureAt: now.Add(-time.Second)}
+	assert.Equal(t, time.Second, p.RetryAfter(recent, now))
+	assert.Zero(t, p.RetryAfter(recent, now.Add(2*time.Second)))
+
+	// Locked out
+	locked := &models.LoginThrottle{
+		Key:           AccountThrottleKey("a@example.com"),
+		Failures:      5,
+		LastFailureAt: now,
+		LockedUntil:   sql.NullTime{Time: now.Add(10 * time.Minute), Valid: true},
+	}
+	assert.Equal(t, 10*time.Minute, p.RetryAfter(locked, now))
+	assert.Zero(t, p.RetryAfter(locked, now.Add(p.FailureWindow+time.Minute)))
+}
+
+func TestLoginThrottlePolicyShouldLock(t *testing.T) {
+	p := DefaultLoginThrottlePolicy()
+
+	assert.False(t, p.ShouldLock(&models.LoginThrottle{Key: AccountThrottleKey("a@example.com"), Failures: 4}))
+	assert.True(t, p.ShouldLock(&models.LoginThrottle{Key: AccountThrottleKey("a@example.com"), Failures: 5}))
+	assert.True(t, p.ShouldLock(&models.LoginThrottle{Key: MFAThrottleKey("user-1"), Failures: 5}))
+
+	// Addresses can be shared by many users, so they get a higher limit
+	assert.False(t, p.ShouldLock(&models.LoginThrottle{Key: IPThrottleKey("10.0.0.1"), Failures: 5}))
+	assert.True(t, p.ShouldLock(&models.LoginThrottle{Key: IPThrottleKey("10.0.0.1"), Failures: 50}))
+
+	assert.Equal(t, "account:alice@example.com", AccountThrottleKey(" Alice@Example.com "))
+}
+
+func TestLoginThrottlePolicyOverLimit(t *testing.T) {
+	p := DefaultLoginThrottlePolicy()
+
+	// The attempt reaching the limit is still checked; only those racing it are refused
+	assert.False(t, p.OverLimit(&models.LoginThrottle{Key: AccountThrottleKey("a@example.com"), Failures: 5}))
+	assert.True(t, p.OverLimit(&models.LoginThrottle{Key: AccountThrottleKey("a@example.com"), Failures: 6}))
+	assert.False(t, p.OverLimit(&models.LoginThrottle{Key: IPThrottleKey("10.0.0.1"), Failures: 50}))
+	assert.True(t, p.OverLimit(&models.LoginThrottle{Key: IPThrottleKey("10.0.0.1"), Failures: 51}))
+
+	p.MaxAccountFailures = 0
+	assert.False(t, p.OverLimit(&models.LoginThrottle{Key: AccountThrottleKey("a@example.com"), Failures: 100}))
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA-1 test key from RFC 6238 Appendix B, base32 encoded.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeMatchesRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8-digit codes; the 6-digit code is its last six digits.
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, want := range vectors {
+		code, err := TOTPCode(rfcSecret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, want, code, "time %d", unix)
+	}
+}
+
+func TestVerifyTOTPAllowsOneStepOfDrift(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	code, err := TOTPCode(secret, now)
+	require.NoError(t, err)
+
+	step, ok := VerifyTOTP(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/30, step)
+
+	_, ok = VerifyTOTP(secret, code, now.Add(30*time.Second))
+	assert.True(t, ok, "previous period is accepted")
+
+	_, ok = VerifyTOTP(secret, code, now.Add(2*time.Minute))
+	assert.False(t, ok, "codes far outside the window are rejected")
+
+	_, ok = VerifyTOTP(secret, "12345", now)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("bob@example.com", "JBSWY3DPEHPK3PXP")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Hospital-Portal:bob@example.com?"))
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=Hospital-Portal")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	require.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Len(t, code, 11)
+		assert.False(t, seen[code])
+		seen[code] = true
+	}
+
+	// Users may type codes in upper case or without the dash.
+	typed := strings.ToUpper(strings.Replace(codes[0], "-", "", 1))
+	assert.Equal(t, HashRecoveryCode(codes[0]), HashRecoveryCode(typed))
+}
+
+func TestMFATokenAndAccessTokenAreNotInterchangeable(t *testing.T) {
+	keys := NewHMACKeyRing([]byte("secret"))
+	u := &models.User{ID: "user-1", Role: "doctor"}
+
+	mfaToken, err := GenerateMFAToken(keys, u)
+	require.NoError(t, err)
+	userID, tokenID, _, err := ParseMFAToken(keys, mfaToken)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", userID)
+	assert.NotEmpty(t, tokenID)
+
+	accessToken, err := GenerateToken(keys, u, "session-1", []string{AuthMethodPassword}, defaultAccessTokenTTL)
+	require.NoError(t, err)
+	_, _, _, err = ParseMFAToken(keys, accessToken)
+	assert.Error(t, err)
+
+	// JWTMiddleware only accepts tokens typed as access tokens.
+	parsed, err := ParseJWT(keys, mfaToken)
+	require.NoError(t, err)
+	assert.NotEqual(t, tokenTypeAccess, parsed.Claims.(jwt.MapClaims)["typ"])
+}
@@ -1,41 +1,345 @@
 package config
 
 import (
-	"database/sql"
 	"fmt"
-	"log"
-	"os"
+	"slices"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq" // PostgreSQL driver
+	auth "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/auth"
+	mail "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/mail"
+	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"golang.org/x/crypto/bcrypt"
 )
 
-// ConnectDB establishes a connection to the PostgreSQL database using environment variables.
-// It returns a *sql.DB instance on success, or an error if the connection fails.
-func ConnectDB() (*sql.DB, error) {
-	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
-	user := os.Getenv("DB_USER")
-	dbname := os.Getenv("DB_NAME")
-	password := os.Getenv("DB_PASSWORD")
+// minJWTSecretLength is the shortest accepted HS256 secret: RFC 7518 requires a key at least as long as the hash.
+const minJWTSecretLength = 32
 
-	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-	host, port, user, password, dbname)
+// sslModes are the sslmode values understood by the PostgreSQL driver.
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
 
-	// Open a new database connection using the "postgres" driver.
-	db, err := sql.Open("postgres", psqlInfo)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database connection: %w", err)
+// Config is the configuration of the application. Load assembles it from, in increasing order of precedence,
+// the defaults, an optional YAML file, environment variables and command line flags.
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Database  Database  `yaml:"database"`
+	Auth      Auth      `yaml:"auth"`
+	Login     Login     `yaml:"login"`
+	Passwords Passwords `yaml:"passwords"`
+	OIDC      OIDC      `yaml:"oidc"`
+	Mail      Mail      `yaml:"mail"`
+	Patients  Patients  `yaml:"patients"`
+}
+
+// Server configures the HTTP server.
+type Server struct {
+	Port             int           `yaml:"port"`
+	MigrateOnStart   bool          `yaml:"migrate_on_start"`   // Apply pending migrations before serving.
+	PasswordResetURL string        `yaml:"password_reset_url"` // Front end page accepting password reset tokens.
+	RequestTimeout   time.Duration `yaml:"request_timeout"`
+	ExportTimeout    time.Duration `yaml:"export_request_timeout"`
+	AuditTimeout     time.Duration `yaml:"audit_request_timeout"`
+	ReadinessTimeout time.Duration `yaml:"readiness_check_timeout"` // Bounds each check of /readyz.
+}
+
+// Database configures the PostgreSQL connection and its pool.
+type Database struct {
+	URL         string `yaml:"url"` // Connection URL or key=value string; replaces every other connection setting.
+	Host        string `yaml:"host"`
+	Port        int    `yaml:"port"`
+	User        string `yaml:"user"`
+	Password    string `yaml:"password"`
+	Name        string `yaml:"name"`
+	SSLMode     string `yaml:"sslmode"`
+	SSLRootCert string `yaml:"sslrootcert"` // CA certificate verifying the server, for verify-ca and verify-full.
+	SSLCert     string `yaml:"sslcert"`     // Client certificate, for servers requiring certificate authentication.
+	SSLKey      string `yaml:"sslkey"`      // Private key of the client certificate; must not be readable by others.
+
+	MaxOpenConns    int           `yaml:"max_open_conns"`    // Connections open at once; 0 means unlimited.
+	MaxIdleConns    int           `yaml:"max_idle_conns"`    // Idle connections kept for reuse.
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"` // Connections are replaced after this long; 0 keeps them.
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+
+	// ConnectRetryTimeout is how long ConnectDB keeps retrying while the database is unreachable, e.g. because
+	// it is still starting next to the application; 0 gives up after the first attempt.
+	ConnectRetryTimeout time.Duration `yaml:"connect_retry_timeout"`
+}
+
+// Auth configures the signing keys and the lifetimes of tokens.
+type Auth struct {
+	JWTSecret          string        `yaml:"jwt_secret"`     // HS256 shared secret, used when KeysDir is empty.
+	KeysDir            string        `yaml:"jwt_keys_dir"`   // Directory of <kid>.pem private keys.
+	ActiveKeyID        string        `yaml:"jwt_active_kid"` // Key signing new tokens; the most recently activated key when empty.
+	KeyGracePeriod     time.Duration `yaml:"jwt_key_grace_period"`
+	AccessTokenTTL     time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL    time.Duration `yaml:"refresh_token_ttl"`
+	PasswordResetTTL   time.Duration `yaml:"password_reset_ttl"`
+	EmergencyAccessTTL time.Duration `yaml:"emergency_access_ttl"`
+}
+
+// Login configures the throttling of failed logins.
+type Login struct {
+	MaxAccountFailures int           `yaml:"max_account_failures"`
+	MaxIPFailures      int           `yaml:"max_ip_failures"`
+	LockoutDuration    time.Duration `yaml:"lockout_duration"`
+	FailureWindow      time.Duration `yaml:"failure_window"`
+}
+
+// Passwords configures the password policy and hashing.
+type Passwords struct {
+	MinLength    int    `yaml:"min_length"`
+	MaxLength    int    `yaml:"max_length"`
+	DenyListFile string `yaml:"denylist_file"`
+	Hasher       string `yaml:"hasher"` // "bcrypt" or "argon2id".
+	BcryptCost   int    `yaml:"bcrypt_cost"`
+}
+
+// OIDC configures single sign-on, which is enabled by setting Issuer.
+type OIDC struct {
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url"`
+	Scopes       []string `yaml:"scopes"`
+	GroupsClaim  string   `yaml:"groups_claim"`
+	RoleMapping  string   `yaml:"role_mapping"` // Comma-separated group=role pairs, e.g. "ward-doctors=doctor".
+}
+
+// Mail configures how e-mails are delivered.
+type Mail struct {
+	Dir string `yaml:"dir"` // Directory receiving one .eml file per message; messages are logged when empty.
+}
+
+// Patients configures the retention of deleted patient records.
+type Patients struct {
+	RetentionYears int           `yaml:"retention_years"`
+	PurgeInterval  time.Duration `yaml:"purge_interval"`
+}
+
+// Default returns the configuration used for every setting that is not configured otherwise.
+func Default() *Config {
+	tokens := auth.DefaultTokenLifetimes()
+	throttle := auth.DefaultLoginThrottlePolicy()
+	passwords := auth.DefaultPasswordPolicy()
+
+	return &Config{
+		Server: Server{
+			Port:             3000,
+			MigrateOnStart:   true,
+			RequestTimeout:   10 * time.Second,
+			ExportTimeout:    30 * time.Second,
+			AuditTimeout:     2 * time.Minute,
+			ReadinessTimeout: 2 * time.Second,
+		},
+		Database: Database{
+			Host:                "localhost",
+			Port:                5432,
+			SSLMode:             "disable",
+			MaxOpenConns:        25,
+			MaxIdleConns:        5,
+			ConnMaxLifetime:     30 * time.Minute,
+			ConnMaxIdleTime:     5 * time.Minute,
+			ConnectRetryTimeout: 30 * time.Second,
+		},
+		Auth: Auth{
+			KeyGracePeriod:     auth.DefaultKeyGracePeriod,
+			AccessTokenTTL:     tokens.Access,
+			RefreshTokenTTL:    tokens.Refresh,
+			PasswordResetTTL:   tokens.PasswordReset,
+			EmergencyAccessTTL: tokens.EmergencyAccess,
+		},
+		Login: Login{
+			MaxAccountFailures: throttle.MaxAccountFailures,
+			MaxIPFailures:      throttle.MaxIPFailures,
+			LockoutDuration:    throttle.LockoutDuration,
+			FailureWindow:      throttle.FailureWindow,
+		},
+		Passwords: Passwords{
+			MinLength:  passwords.MinLength,
+			MaxLength:  passwords.MaxLength,
+			Hasher:     "bcrypt",
+			BcryptCost: bcrypt.DefaultCost,
+		},
+		OIDC: OIDC{
+			Scopes:      []string{"profile", "email", "groups"},
+			GroupsClaim: "groups",
+		},
+		Patients: Patients{
+			RetentionYears: 10,
+			PurgeInterval:  24 * time.Hour,
+		},
+	}
+}
+
+// Validate checks the configuration as a whole, reporting every problem at once by the name of its
+// environment variable.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
 	}
 
-	// Ping the database to verify the connection is active and valid.
-	// This checks if the database server is reachable and credentials are correct.
-	err = db.Ping()
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "PORT must be between 1 and 65535, got %d", c.Server.Port)
+	if c.Database.URL != "" {
+		err := c.Database.checkURL()
+		check(err == nil, "DB_URL is invalid: %v", err)
+	} else {
+		check(c.Database.Host != "", "DB_HOST must be set")
+		check(c.Database.Port > 0 && c.Database.Port <= 65535, "DB_PORT must be between 1 and 65535, got %d", c.Database.Port)
+		check(c.Database.User != "", "DB_USER must be set")
+		check(c.Database.Name != "", "DB_NAME must be set")
+		check(slices.Contains(sslModes, c.Database.SSLMode), "DB_SSLMODE must be one of %s, got %q", strings.Join(sslModes, ", "), c.Database.SSLMode)
+		check(c.Database.SSLMode != "disable" || c.Database.SSLRootCert == "" && c.Database.SSLCert == "" && c.Database.SSLKey == "",
+			"DB_SSLROOTCERT, DB_SSLCERT and DB_SSLKEY need a DB_SSLMODE other than disable")
+		check((c.Database.SSLCert == "") == (c.Database.SSLKey == ""), "DB_SSLCERT and DB_SSLKEY must be set together")
+	}
+	check(c.Database.MaxOpenConns >= 0, "DB_MAX_OPEN_CONNS must not be negative, got %d", c.Database.MaxOpenConns)
+	check(c.Database.MaxIdleConns >= 0, "DB_MAX_IDLE_CONNS must not be negative, got %d", c.Database.MaxIdleConns)
+	check(c.Database.MaxOpenConns == 0 || c.Database.MaxIdleConns <= c.Database.MaxOpenConns,
+		"DB_MAX_IDLE_CONNS (%d) is greater than DB_MAX_OPEN_CONNS (%d)", c.Database.MaxIdleConns, c.Database.MaxOpenConns)
+	check(c.Database.ConnMaxLifetime >= 0, "DB_CONN_MAX_LIFETIME must not be negative, got %s", c.Database.ConnMaxLifetime)
+	check(c.Database.ConnMaxIdleTime >= 0, "DB_CONN_MAX_IDLE_TIME must not be negative, got %s", c.Database.ConnMaxIdleTime)
+	check(c.Database.ConnectRetryTimeout >= 0, "DB_CONNECT_RETRY_TIMEOUT must not be negative, got %s", c.Database.ConnectRetryTimeout)
+
+	if c.Auth.KeysDir == "" {
+		check(c.Auth.JWTSecret != "", "either JWT_KEYS_DIR or JWT_SECRET must be set")
+		check(c.Auth.JWTSecret == "" || len(c.Auth.JWTSecret) >= minJWTSecretLength,
+			"JWT_SECRET must be at least %d bytes long, got %d", minJWTSecretLength, len(c.Auth.JWTSecret))
+	}
+	check(c.Auth.KeyGracePeriod >= 0, "JWT_KEY_GRACE_PERIOD must not be negative, got %s", c.Auth.KeyGracePeriod)
+
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"REQUEST_TIMEOUT", c.Server.RequestTimeout},
+		{"EXPORT_REQUEST_TIMEOUT", c.Server.ExportTimeout},
+		{"AUDIT_REQUEST_TIMEOUT", c.Server.AuditTimeout},
+		{"READINESS_CHECK_TIMEOUT", c.Server.ReadinessTimeout},
+		{"ACCESS_TOKEN_TTL", c.Auth.AccessTokenTTL},
+		{"REFRESH_TOKEN_TTL", c.Auth.RefreshTokenTTL},
+		{"PASSWORD_RESET_TTL", c.Auth.PasswordResetTTL},
+		{"EMERGENCY_ACCESS_TTL", c.Auth.EmergencyAccessTTL},
+		{"LOGIN_LOCKOUT_DURATION", c.Login.LockoutDuration},
+		{"LOGIN_FAILURE_WINDOW", c.Login.FailureWindow},
+		{"PATIENT_PURGE_INTERVAL", c.Patients.PurgeInterval},
+	} {
+		check(d.value > 0, "%s must be a positive duration, got %s", d.name, d.value)
+	}
+	for _, n := range []struct {
+		name  string
+		value int
+	}{
+		{"LOGIN_MAX_ACCOUNT_FAILURES", c.Login.MaxAccountFailures},
+		{"LOGIN_MAX_IP_FAILURES", c.Login.MaxIPFailures},
+		{"PASSWORD_MIN_LENGTH", c.Passwords.MinLength},
+		{"PATIENT_RETENTION_YEARS", c.Patients.RetentionYears},
+	} {
+		check(n.value > 0, "%s must be positive, got %d", n.name, n.value)
+	}
+
+	check(c.Passwords.MaxLength >= c.Passwords.MinLength,
+		"PASSWORD_MAX_LENGTH (%d) is less than PASSWORD_MIN_LENGTH (%d)", c.Passwords.MaxLength, c.Passwords.MinLength)
+	_, err := models.NewPasswordHasher(c.Passwords.Hasher, c.Passwords.BcryptCost)
+	check(err == nil, "PASSWORD_HASHER must be bcrypt or argon2id, got %q", c.Passwords.Hasher)
+	check(c.Passwords.BcryptCost >= bcrypt.MinCost && c.Passwords.BcryptCost <= bcrypt.MaxCost,
+		"BCRYPT_COST must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, c.Passwords.BcryptCost)
+
+	if c.OIDC.Issuer != "" {
+		check(c.OIDC.ClientID != "" && c.OIDC.RedirectURL != "", "OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set when OIDC_ISSUER is set")
+		check(c.OIDC.GroupsClaim != "", "OIDC_GROUPS_CLAIM must be set when OIDC_ISSUER is set")
+		mapping, err := auth.ParseRoleMapping(c.OIDC.RoleMapping)
+		switch {
+		case err != nil:
+			problems = append(problems, "OIDC_ROLE_MAPPING: "+err.Error())
+		case len(mapping) == 0:
+			problems = append(problems, "OIDC_ROLE_MAPPING must map at least one group to a role")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// ListenAddr returns the address the API server listens on.
+func (c *Config) ListenAddr() string {
+	return fmt.Sprintf(":%d", c.Server.Port)
+}
+
+// KeyRing loads the keys signing and verifying tokens: the keys in JWT_KEYS_DIR when it is set, and the
+// JWT_SECRET shared secret otherwise.
+func (c *Config) KeyRing() (*auth.KeyRing, error) {
+	if c.Auth.KeysDir != "" {
+		return auth.LoadKeyRing(c.Auth.KeysDir, c.Auth.ActiveKeyID, c.Auth.KeyGracePeriod)
+	}
+	return auth.NewHMACKeyRing([]byte(c.Auth.JWTSecret)), nil
+}
+
+// TokenLifetimes returns how long issued tokens and grants stay valid.
+func (c *Config) TokenLifetimes() auth.TokenLifetimes {
+	return auth.TokenLifetimes{
+		Access:          c.Auth.AccessTokenTTL,
+		Refresh:         c.Auth.RefreshTokenTTL,
+		PasswordReset:   c.Auth.PasswordResetTTL,
+		EmergencyAccess: c.Auth.EmergencyAccessTTL,
+	}
+}
+
+// LoginThrottlePolicy returns the throttling of failed logins, with the default delays.
+func (c *Config) LoginThrottlePolicy() auth.LoginThrottlePolicy {
+	p := auth.DefaultLoginThrottlePolicy()
+	p.MaxAccountFailures = c.Login.MaxAccountFailures
+	p.MaxIPFailures = c.Login.MaxIPFailures
+	p.LockoutDuration = c.Login.LockoutDuration
+	p.FailureWindow = c.Login.FailureWindow
+	return p
+}
+
+// PasswordPolicy returns the rules for new passwords, reading the deny list if one is configured.
+func (c *Config) PasswordPolicy() (auth.PasswordPolicy, error) {
+	p := auth.DefaultPasswordPolicy()
+	p.MinLength = c.Passwords.MinLength
+	p.MaxLength = c.Passwords.MaxLength
+	if c.Passwords.DenyListFile != "" {
+		denyList, err := auth.LoadPasswordDenyList(c.Passwords.DenyListFile)
+		if err != nil {
+			return p, err
+		}
+		p.DenyList = denyList
+	}
+	return p, nil
+}
+
+// PasswordHasher returns the algorithm hashing new passwords.
+func (c *Config) PasswordHasher() (models.PasswordHasher, error) {
+	return models.NewPasswordHasher(c.Passwords.Hasher, c.Passwords.BcryptCost)
+}
+
+// OIDCConfig returns the single sign-on configuration, or nil when single sign-on is not enabled.
+func (c *Config) OIDCConfig() (*auth.OIDCConfig, error) {
+	if c.OIDC.Issuer == "" {
+		return nil, nil
+	}
+	mapping, err := auth.ParseRoleMapping(c.OIDC.RoleMapping)
 	if err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to connect to the database: %w", err)
+		return nil, err
 	}
+	return &auth.OIDCConfig{
+		Issuer:       c.OIDC.Issuer,
+		ClientID:     c.OIDC.ClientID,
+		ClientSecret: c.OIDC.ClientSecret,
+		RedirectURL:  c.OIDC.RedirectURL,
+		Scopes:       c.OIDC.Scopes,
+		GroupsClaim:  c.OIDC.GroupsClaim,
+		RoleMapping:  mapping,
+	}, nil
+}
 
-	log.Println("Connected to database!")
-	fmt.Println("Successfully connected to the database!")
-	return db, nil
+// Mailer returns the mailer delivering e-mails.
+func (c *Config) Mailer() mail.Mailer {
+	return mail.NewMailer(c.Mail.Dir)
 }
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq" // PostgreSQL driver
+)
+
+const (
+	// pingTimeout bounds every attempt to reach the database, so that an unresponsive host cannot use up
+	// the whole retry period in one attempt.
+	pingTimeout = 5 * time.Second
+
+	// minRetryDelay and maxRetryDelay bound the exponential backoff between connection attempts.
+	minRetryDelay = 500 * time.Millisecond
+	maxRetryDelay = 10 * time.Second
+)
+
+// ConnectDB establishes a connection pool to the PostgreSQL database described by cfg. While the database
+// is unreachable it retries with exponential backoff for cfg.ConnectRetryTimeout, so that the application can
+// start alongside its database; errors that retrying cannot fix, such as wrong credentials, fail at once.
+func ConnectDB(ctx context.Context, cfg Database) (*sql.DB, error) {
+	// Open a new database connection using the "postgres" driver.
+	db, err := sql.Open("postgres", cfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database connection: %w", err)
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	// Ping the database to verify the connection is active and valid.
+	// This checks if the database server is reachable and credentials are correct.
+	giveUp := time.Now().Add(cfg.ConnectRetryTimeout)
+	for delay := minRetryDelay; ; delay = min(2*delay, maxRetryDelay) {
+		err = ping(ctx, db)
+		if err == nil {
+			break
+		}
+		wait := min(delay, time.Until(giveUp))
+		if wait <= 0 || !retryable(err) {
+			db.Close()
+			return nil, fmt.Errorf("failed to connect to the database: %w", err)
+		}
+
+		log.Printf("Database is not reachable (%v), retrying in %s", err, wait.Round(time.Millisecond))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			db.Close()
+			return nil, ctx.Err()
+		}
+	}
+
+	log.Println("Connected to database!")
+	return db, nil
+}
+
+// ping makes one attempt to reach the database.
+func ping(ctx context.Context, db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return db.PingContext(ctx)
+}
+
+// retryable reports whether a failed connection attempt may succeed later: the server could not be reached,
+// dropped the connection or is still starting up.
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08 is connection exceptions; 57P03 is cannot_connect_now, sent while the server starts.
+		return pqErr.Code.Class() == "08" || pqErr.Code == "57P03"
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// DSN returns the connection string of the database: URL when it is set, and the other connection
+// settings in key=value form otherwise.
+func (cfg Database) DSN() string {
+	if cfg.URL != "" {
+		return cfg.URL
+	}
+
+	params := []struct{ key, value string }{
+		{"host", cfg.Host},
+		{"port", strconv.Itoa(cfg.Port)},
+		{"user", cfg.User},
+		{"password", cfg.Password},
+		{"dbname", cfg.Name},
+		{"sslmode", cfg.SSLMode},
+		{"sslrootcert", cfg.SSLRootCert},
+		{"sslcert", cfg.SSLCert},
+		{"sslkey", cfg.SSLKey},
+	}
+
+	var b strings.Builder
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(p.key + "=" + quoteDSNValue(p.value))
+	}
+	return b.String()
+}
+
+// checkURL reports syntax errors in URL when it is a postgres:// URL; key=value strings are left to the driver.
+// The URL itself is kept out of the error, as it usually contains the password.
+func (cfg Database) checkURL() error {
+	if !strings.HasPrefix(cfg.URL, "postgres://") && !strings.HasPrefix(cfg.URL, "postgresql://") {
+		return nil
+	}
+	_, err := pq.ParseURL(cfg.URL)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// quoteDSNValue quotes a connection string value, so that passwords may contain spaces and quotes.
+func quoteDSNValue(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
@@ -0,0 +1,85 @@
+// Package mail delivers e-mail notifications such as password reset links.
+// Delivery goes through the Mailer interface so that deployments can plug in their own transport.
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Message is a plain-text e-mail.
+type Message struct {
+	To      string // Recipient address.
+	Subject string // Subject line.
+	Body    string // Plain-text body.
+}
+
+// Mailer sends e-mail messages.
+type Mailer interface {
+	Send(Message) error
+}
+
+// LogMailer writes messages to the application log instead of sending them. Intended for local development.
+type LogMailer struct{}
+
+// Send logs the message.
+func (LogMailer) Send(m Message) error {
+	log.Printf("Mail to %s: %s\n%s", m.To, m.Subject, m.Body)
+	return nil
+}
+
+// FileMailer writes every message to its own file in Dir, where it can be opened with any mail client.
+type FileMailer struct {
+	Dir string // Directory receiving one .eml file per message; created if missing.
+}
+
+// Send writes the message to a new file in m.Dir.
+func (f FileMailer) Send(m Message) error {
+	if err := os.MkdirAll(f.Dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+
+	now := time.Now()
+	pattern := fmt.Sprintf("%s-%s-*.eml", now.UTC().Format("20060102T150405"), sanitizeFileName(m.To))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(m.Body)
+
+	// CreateTemp picks a unique name, so messages sent in the same second do not overwrite each other.
+	file, err := os.CreateTemp(f.Dir, pattern)
+	if err != nil {
+		return fmt.Errorf("failed to create mail file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(b.String()); err != nil {
+		return fmt.Errorf("failed to write mail file: %w", err)
+	}
+	return file.Close()
+}
+
+// NewMailer returns a FileMailer writing to dir, or a LogMailer when dir is empty.
+func NewMailer(dir string) Mailer {
+	if dir != "" {
+		return FileMailer{Dir: dir}
+	}
+	return LogMailer{}
+}
+
+// sanitizeFileName replaces characters that are unsafe in file names.
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '@':
+			return r
+		}
+		return '_'
+	}, s)
+}
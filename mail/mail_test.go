@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMailerWritesOneFilePerMessage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	m := FileMailer{Dir: dir}
+
+	require.NoError(t, m.Send(Message{To: "alice@example.com", Subject: "Hello", Body: "first"}))
+	require.NoError(t, m.Send(Message{To: "../bob@example.com", Subject: "Hello", Body: "second"}))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	for _, e := range entries {
+		assert.True(t, strings.HasSuffix(e.Name(), ".eml"))
+		assert.NotContains(t, e.Name(), "/")
+	}
+
+	var contents []string
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		require.NoError(t, err)
+		contents = append(contents, string(data))
+	}
+	assert.Contains(t, strings.Join(contents, "\n"), "To: alice@example.com\r\nSubject: Hello\r\n")
+	assert.Contains(t, strings.Join(contents, "\n"), "\r\n\r\nsecond")
+}
@@ -1,42 +1,364 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
-	"fmt"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	auth "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/auth"
 	config "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/config"
+	migrations "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/migrations"
 	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
 	routes "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/routes"
 	"github.com/joho/godotenv"
 )
 
+// usage lists the commands of the binary; "serve" runs when none is given.
+const usage = `Usage: app [settings] [command] [arguments]
+
+Commands:
+  serve                                  start the API server (the default)
+  migrate up | down [steps] | status     apply, revert or list schema migrations
+  user create -name N -email E -role R [-password P]
+                                         create an account; a password is generated unless one is given
+  user disable | enable <id or email>    disable or re-enable an account
+  user set-role <id or email> <role>     change the role of an account
+  seed [-staff N] [-patients N] [-seed S] [-password P]
+                                         fill the database with fake staff and patients for development
+  audit verify                           check the audit log's hash chain
+  export [-format csv|json] [-o file]    export every patient record
+  purge-patients                         purge deleted patient records past their retention period
+  help                                   show this message and the settings
+
+Settings are read from a YAML file (-config), the environment and flags, which take precedence in that order.
+`
+
+// version is the release of the binary reported by /version; release builds set it with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// command runs a subcommand with its arguments and returns the process exit code: 0 on success, 1 when
+// the command failed and 2 when it was used wrongly.
+type command func(ctx context.Context, cfg *config.Config, db *sql.DB, store *models.PostgresStore, args []string) int
+
+// commands maps the names of subcommands to their implementations.
+var commands = map[string]command{
+	"serve":          serve,
+	"migrate":        migrateCommand,
+	"user":           userCommand,
+	"seed":           seedCommand,
+	"audit":          auditCommand,
+	"export":         exportCommand,
+	"purge-patients": purgeCommand,
+}
+
 func main() {
-	err := godotenv.Load()
-	if err != nil {
+	// A .env file is optional; deployments usually set the environment themselves.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
-	listenPort := os.Getenv("PORT")
-	if listenPort == "" {
-		listenPort = "3000"
+	cfg, args, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		printUsage(os.Stdout)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\nRun \"app help\" to list the commands and settings.\n", err)
+		os.Exit(2)
 	}
-	listenAddr := ":" + listenPort
 
-	log.Printf("Application starting on %s", listenAddr)
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage(os.Stdout)
+		return
+	}
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	db, err := config.ConnectDB()
+	ctx := context.Background()
+	db, err := config.ConnectDB(ctx, cfg.Database)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	defer db.Close()
+	// Hashes made with another algorithm or a lower bcrypt cost are upgraded when their users log in.
+	hasher, err := cfg.PasswordHasher()
+	if err != nil {
+		log.Fatalf("Failed to configure password hashing: %v", err)
+	}
 
-	store, err := models.NewPostgresStore(db)
+	store, err := models.NewPostgresStore(db, hasher)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	server := routes.NewAPIServer(listenAddr, store, store)
+	code := run(ctx, cfg, db, store, args)
+	db.Close()
+	os.Exit(code)
+}
+
+// printUsage writes the commands and settings of the binary to w.
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, usage, "\nSettings:\n")
+	config.PrintFlags(w)
+}
+
+// serve starts the API server, after applying pending migrations, and purges deleted patient records in the
+// background. It only returns when the server could not be started.
+func serve(ctx context.Context, cfg *config.Config, db *sql.DB, store *models.PostgresStore, args []string) int {
+	listenAddr := cfg.ListenAddr()
+
+	log.Printf("Application starting on %s", listenAddr)
+
+	// Pending migrations are applied on startup unless MIGRATE_ON_START=false, e.g. when a deployment
+	// runs "migrate up" as a separate step.
+	if cfg.Server.MigrateOnStart {
+		if code := migrate(ctx, db, []string{"up"}); code != 0 {
+			log.Print("Failed to migrate the database schema")
+			return code
+		}
+	}
+
+	passwords, err := cfg.PasswordPolicy()
+	if err != nil {
+		log.Printf("Failed to load password policy: %v", err)
+		return 1
+	}
+
+	keys, err := cfg.KeyRing()
+	if err != nil {
+		log.Printf("Failed to load JWT signing keys: %v", err)
+		return 1
+	}
+	log.Printf("Signing tokens with key %q", keys.ActiveKeyID())
+
+	// Single sign-on is optional; it is enabled by setting OIDC_ISSUER.
+	var oidc *auth.OIDCProvider
+	oidcConfig, err := cfg.OIDCConfig()
+	if err != nil {
+		log.Printf("Failed to load single sign-on configuration: %v", err)
+		return 1
+	}
+	if oidcConfig != nil {
+		if oidc, err = auth.NewOIDCProvider(ctx, *oidcConfig, nil); err != nil {
+			log.Printf("Failed to set up single sign-on: %v", err)
+			return 1
+		}
+		log.Printf("Single sign-on enabled with %s", oidcConfig.Issuer)
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Printf("Failed to load migrations: %v", err)
+		return 1
+	}
+	readiness := []routes.ReadinessCheck{
+		{Name: "database", Check: func(ctx context.Context) error {
+			if err := db.PingContext(ctx); err != nil {
+				log.Printf("Readiness check: database ping failed: %v", err)
+				return errors.New("database unreachable")
+			}
+			return nil
+		}},
+		{Name: "migrations", Check: func(ctx context.Context) error {
+			// Read-only: probes must not queue behind, or hold up, an instance that is migrating.
+			pending, err := migrator.Pending(ctx)
+			if err != nil {
+				log.Printf("Readiness check: failed to read migration status: %v", err)
+				return errors.New("migration status unavailable")
+			}
+			if pending > 0 {
+				return fmt.Errorf("%d migrations pending", pending)
+			}
+			return nil
+		}},
+	}
+
+	// Background work only starts once startup has succeeded, so that a server that fails to start never
+	// purges records.
+	// Reload the signing keys on SIGHUP so that keys can be rotated without a restart.
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if err := keys.Reload(); err != nil {
+				log.Printf("Failed to reload JWT signing keys: %v", err)
+				continue
+			}
+			log.Printf("Reloaded JWT signing keys, now signing with %q", keys.ActiveKeyID())
+		}
+	}()
+
+	go func() {
+		for ; ; time.Sleep(cfg.Patients.PurgeInterval) {
+			if err := purgeDeletedPatients(ctx, store, cfg.Patients.RetentionYears); err != nil {
+				log.Printf("Failed to purge deleted patients: %v", err)
+			}
+		}
+	}()
+
+	server := routes.NewAPIServer(store, store, keys, cfg.Mailer(), routes.Options{
+		ListenAddr: listenAddr,
+		Passwords:  passwords,
+		Throttle:   cfg.LoginThrottlePolicy(),
+		Tokens:     cfg.TokenLifetimes(),
+		Timeouts: routes.RequestTimeouts{
+			Default:   cfg.Server.RequestTimeout,
+			Export:    cfg.Server.ExportTimeout,
+			Audit:     cfg.Server.AuditTimeout,
+			Readiness: cfg.Server.ReadinessTimeout,
+		},
+		ResetURL:  cfg.Server.PasswordResetURL,
+		OIDC:      oidc,
+		DBStats:   db.Stats,
+		Readiness: readiness,
+		Build:     routes.ReadBuildInfo(version),
+	})
 	server.Run()
+	return 0
+}
+
+// migrateCommand applies or reverts schema migrations: "migrate up", "migrate down [steps]" or "migrate status".
+func migrateCommand(ctx context.Context, cfg *config.Config, db *sql.DB, store *models.PostgresStore, args []string) int {
+	return migrate(ctx, db, args)
+}
+
+// auditCommand works with the audit log; "audit verify" is the only subcommand.
+func auditCommand(ctx context.Context, cfg *config.Config, db *sql.DB, store *models.PostgresStore, args []string) int {
+	if len(args) != 1 || args[0] != "verify" {
+		fmt.Fprint(os.Stderr, "Usage: app audit verify\n")
+		return 2
+	}
+	return verifyAuditLog(ctx, store)
+}
+
+// purgeCommand runs one purge of the deleted patient records past their retention period.
+func purgeCommand(ctx context.Context, cfg *config.Config, db *sql.DB, store *models.PostgresStore, args []string) int {
+	if err := purgeDeletedPatients(ctx, store, cfg.Patients.RetentionYears); err != nil {
+		log.Printf("Failed to purge deleted patients: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// migrate runs the migration command in args ("up", "down [steps]" or "status"; "up" when empty) and
+// reports the result, returning the process exit code.
+func migrate(ctx context.Context, db *sql.DB, args []string) int {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Printf("Failed to load migrations: %v", err)
+		return 2
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			log.Printf("Applied migration %d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Printf("Failed to migrate: %v", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			log.Println("Database schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Printf("The number of migrations to revert must be positive, got %q", args[1])
+				return 2
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			log.Printf("Reverted migration %d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Printf("Failed to revert migrations: %v", err)
+			return 1
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Printf("Failed to read migration status: %v", err)
+			return 1
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			if s.Modified {
+				state += " (MODIFIED since)"
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+	default:
+		log.Printf("Unknown migrate command %q; use up, down [steps] or status", command)
+		return 2
+	}
+	return 0
+}
+
+// verifyAuditLog checks the audit log's hash chain and reports the result, returning the process exit code:
+// 0 when the chain is intact, 1 when it is broken and 2 when it could not be checked.
+func verifyAuditLog(ctx context.Context, store *models.PostgresStore) int {
+	result, err := store.VerifyAuditLog(ctx)
+	if err != nil {
+		log.Printf("Failed to verify the audit log: %v", err)
+		return 2
+	}
+	if !result.Valid {
+		fmt.Printf("Audit log is BROKEN at event %d: %s\n", result.InvalidID, result.Problem)
+		return 1
+	}
+	fmt.Printf("Audit log is intact: %d events, last hash %s\n", result.Events, result.LastHash)
+	return 0
+}
+
+// purgeDeletedPatients permanently removes the patient records deleted more than retentionYears ago and records
+// every purge in the audit log; records are only purged together with their events.
+func purgeDeletedPatients(ctx context.Context, store *models.PostgresStore, retentionYears int) error {
+	event := &models.AuditEvent{
+		ActorRole: "system",
+		Action:    models.AuditActionPatientPurge,
+		Detail:    fmt.Sprintf("retention period of %d years elapsed", retentionYears),
+	}
+	ids, err := store.PurgeDeletedPatients(ctx, time.Now().AddDate(-retentionYears, 0, 0), event)
+	if err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		log.Printf("Purged %d deleted patient records past the %d year retention period", len(ids), retentionYears)
+	}
+	return nil
 }
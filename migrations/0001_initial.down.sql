@@ -0,0 +1,24 @@
+-- Removes the whole schema, including every patient record and the audit log.
+DROP TABLE IF EXISTS
+    patient_versions,
+    audit_log,
+    care_team_members,
+    emergency_access_uses,
+    emergency_access_grants,
+    sessions,
+    oidc_login_states,
+    api_keys,
+    password_reset_tokens,
+    login_lockouts,
+    login_throttles,
+    recovery_codes,
+    invitations,
+    role_permissions,
+    permissions,
+    revoked_access_tokens,
+    refresh_tokens,
+    patients,
+    users,
+    roles;
+
+DROP FUNCTION IF EXISTS audit_log_append_only();
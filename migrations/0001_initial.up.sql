@@ -0,0 +1,450 @@
+CREATE TABLE IF NOT EXISTS users (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) UNIQUE NOT NULL,
+    password VARCHAR(255) NOT NULL,
+    role VARCHAR(255) NOT NULL
+);
+
+-- Add the CHECK constraint for the role column if it doesn't exist
+DO $$ BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'users_role_check') THEN
+        ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role::text = ANY (ARRAY['receptionist'::character varying, 'doctor'::character varying]::text[]));
+    END IF;
+END $$;
+
+
+CREATE TABLE IF NOT EXISTS patients (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) NOT NULL,
+    age INTEGER NOT NULL,
+    gender VARCHAR(255) NOT NULL,
+    diagnosis TEXT, -- This column is NULLABLE
+    created_by UUID NOT NULL
+);
+
+-- Add the Foreign Key constraint if it doesn't exist
+DO $$ BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'patients_created_by_fkey') THEN
+        ALTER TABLE patients ADD CONSTRAINT patients_created_by_fkey FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE RESTRICT;
+    END IF;
+END $$;
+
+-- Refresh tokens are stored hashed; tokens issued from one login share a family_id so that
+-- reuse of a rotated token can revoke the whole family.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id UUID NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    replaced_by UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+
+-- Access tokens revoked before their natural expiry (e.g. on logout), keyed by their jti claim.
+CREATE TABLE IF NOT EXISTS revoked_access_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+
+-- Permission-based access control: roles are mapped to sets of permissions.
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(255) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    name VARCHAR(255) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role VARCHAR(255) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    permission VARCHAR(255) NOT NULL REFERENCES permissions(name) ON DELETE CASCADE,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('receptionist', 'Front desk staff registering and maintaining patient records'),
+    ('doctor', 'Clinicians reviewing and diagnosing patients')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+    ('patient:read', 'List and view patient records'),
+    ('patient:create', 'Register new patients'),
+    ('patient:update', 'Change patient demographics'),
+    ('patient:diagnose', 'Set or change a patient diagnosis'),
+    ('patient:delete', 'Delete patient records'),
+    ('patient:export', 'Export patient records')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('receptionist', 'patient:read'),
+    ('receptionist', 'patient:create'),
+    ('receptionist', 'patient:update'),
+    ('receptionist', 'patient:delete'),
+    ('receptionist', 'patient:export'),
+    ('doctor', 'patient:read'),
+    ('doctor', 'patient:diagnose'),
+    ('doctor', 'patient:export')
+ON CONFLICT (role, permission) DO NOTHING;
+
+-- Roles are now data: replace the hard-coded CHECK constraint with a foreign key to roles.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+
+DO $$ BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'users_role_fkey') THEN
+        ALTER TABLE users ADD CONSTRAINT users_role_fkey FOREIGN KEY (role) REFERENCES roles(name) ON UPDATE CASCADE ON DELETE RESTRICT;
+    END IF;
+END $$;
+
+
+-- Administrators manage user accounts.
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Administrators managing user accounts and access')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+    ('user:read', 'List and view user accounts'),
+    ('user:manage', 'Create, invite, change the role of, disable and delete user accounts')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'user:read'),
+    ('admin', 'user:manage')
+ON CONFLICT (role, permission) DO NOTHING;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- Single-use registration invitations; only the token hash is stored.
+CREATE TABLE IF NOT EXISTS invitations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    role VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    invited_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT invitations_role_fkey FOREIGN KEY (role) REFERENCES roles(name) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+-- TOTP two-factor authentication (RFC 6238).
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT; -- Last accepted time step; codes cannot be replayed.
+
+-- Roles with mfa_required set cannot use the API until their members have enrolled a second factor,
+-- e.g. UPDATE roles SET mfa_required = true WHERE name = 'doctor';
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS mfa_required BOOLEAN NOT NULL DEFAULT false;
+
+-- Single-use recovery codes for users who lost their authenticator; only hashes are stored.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (user_id, code_hash)
+);
+
+-- Authentication methods (e.g. pwd, otp) of the login a refresh token family belongs to.
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS auth_methods TEXT[] NOT NULL DEFAULT '{pwd}';
+
+-- Failed login attempts per throttle key ("account:<email>", "ip:<address>", "mfa:<user id>").
+-- Rows are kept for unknown emails too, so responses do not reveal which accounts exist.
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key VARCHAR(320) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until TIMESTAMPTZ
+);
+
+-- Audit trail of every lockout and who lifted it.
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    key VARCHAR(320) NOT NULL,
+    failures INTEGER NOT NULL,
+    locked_until TIMESTAMPTZ NOT NULL,
+    unlocked_at TIMESTAMPTZ,
+    unlocked_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS login_lockouts_key_idx ON login_lockouts (key, created_at DESC);
+
+-- Single-use password reset tokens; only the token hash is stored.
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Service accounts are users without a password that authenticate with API keys, so that
+-- integrations appear as actors (e.g. patients.created_by) like any other user.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS service_account BOOLEAN NOT NULL DEFAULT false;
+
+-- Long-lived API keys of service accounts; only the key hash is stored. The prefix is the
+-- non-secret start of the key, shown in listings to tell keys apart.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    service_account_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(32) NOT NULL,
+    key_hash VARCHAR(64) UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_service_account_id_idx ON api_keys (service_account_id);
+
+-- Users who sign in with OpenID Connect single sign-on are identified by the identity
+-- provider's issuer and subject; their email may change at the identity provider.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_issuer TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_subject TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_oidc_identity_idx ON users (oidc_issuer, oidc_subject) WHERE oidc_subject IS NOT NULL;
+
+-- Single sign-on logins in progress, from the redirect to the identity provider until its
+-- callback; only the hash of the state parameter is stored.
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state_hash VARCHAR(64) PRIMARY KEY,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- One row per login on a device. The session ID is carried in the "sid" claim of access
+-- tokens and is the family ID of the session's refresh tokens.
+CREATE TABLE IF NOT EXISTS sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+-- Logins from before sessions were tracked become sessions of an unknown device, so that
+-- their refresh tokens keep working.
+INSERT INTO sessions (id, user_id, created_at, last_seen_at, expires_at)
+SELECT family_id, user_id, min(created_at), max(created_at), max(expires_at)
+FROM refresh_tokens WHERE revoked_at IS NULL
+GROUP BY family_id, user_id
+ON CONFLICT (id) DO NOTHING;
+
+-- Break-the-glass permission: time-boxed access to a single patient record outside the
+-- caller's normal permissions, in exchange for a written justification.
+INSERT INTO permissions (name, description) VALUES
+    ('patient:emergency_access', 'Break the glass to read a patient record outside normal permissions'),
+    ('audit:read', 'Review audit trails such as the emergency access report')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('doctor', 'patient:emergency_access'),
+    ('admin', 'audit:read')
+ON CONFLICT (role, permission) DO NOTHING;
+
+-- Emergency access grants are kept for compliance review: users referenced here cannot be
+-- deleted, and grants outlive the patient records they were for.
+CREATE TABLE IF NOT EXISTS emergency_access_grants (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+    patient_id UUID NOT NULL,
+    reason TEXT NOT NULL CHECK (length(trim(reason)) > 0),
+    ip TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS emergency_access_grants_user_patient_idx ON emergency_access_grants (user_id, patient_id, expires_at);
+CREATE INDEX IF NOT EXISTS emergency_access_grants_created_at_idx ON emergency_access_grants (created_at);
+
+-- Every request made under an emergency access grant.
+CREATE TABLE IF NOT EXISTS emergency_access_uses (
+    id BIGSERIAL PRIMARY KEY,
+    grant_id UUID NOT NULL REFERENCES emergency_access_grants(id) ON DELETE RESTRICT,
+    action TEXT NOT NULL,
+    used_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS emergency_access_uses_grant_id_idx ON emergency_access_uses (grant_id);
+
+-- Care teams: the clinicians responsible for a patient over a period of time. Callers without
+-- patient:read_all only see and act on the patients of their current care teams.
+INSERT INTO permissions (name, description) VALUES
+    ('patient:read_all', 'Act on every patient record, not only those of the caller''s care teams'),
+    ('careteam:manage', 'Assign clinicians to patient care teams and end assignments')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('receptionist', 'patient:read_all'),
+    ('admin', 'careteam:manage')
+ON CONFLICT (role, permission) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS care_team_members (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    patient_id UUID NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(255) NOT NULL DEFAULT '',
+    starts_on DATE NOT NULL DEFAULT CURRENT_DATE,
+    ends_on DATE,
+    assigned_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT care_team_members_dates_check CHECK (ends_on IS NULL OR ends_on >= starts_on)
+);
+
+CREATE INDEX IF NOT EXISTS care_team_members_user_id_idx ON care_team_members (user_id, patient_id);
+CREATE INDEX IF NOT EXISTS care_team_members_patient_id_idx ON care_team_members (patient_id);
+
+-- Append-only, hash-chained audit log of every read, listing, export and change of patient records.
+-- Actor and patient IDs are kept as plain text so events outlive the accounts and records they mention.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    actor_id TEXT NOT NULL DEFAULT '',
+    actor_role TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    patient_id TEXT NOT NULL DEFAULT '',
+    fields TEXT[] NOT NULL DEFAULT '{}',
+    detail TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    prev_hash VARCHAR(64) NOT NULL DEFAULT '',
+    hash VARCHAR(64) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_patient_id_idx ON audit_log (patient_id, id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id, id);
+CREATE INDEX IF NOT EXISTS audit_log_occurred_at_idx ON audit_log (occurred_at);
+
+-- Refuse changes to recorded events; the hash chain detects changes made around this trigger.
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+-- Revision history: every change of a patient record adds a version; versions are never changed.
+CREATE TABLE IF NOT EXISTS patient_versions (
+    patient_id UUID NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    age INTEGER NOT NULL,
+    gender VARCHAR(255) NOT NULL,
+    diagnosis TEXT,
+    edited_by UUID NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+    edited_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    change VARCHAR(32) NOT NULL,
+    restored_from INTEGER,
+    PRIMARY KEY (patient_id, version)
+);
+
+-- Patients recorded before revision history start with a snapshot of their current state.
+INSERT INTO patient_versions (patient_id, version, name, age, gender, diagnosis, edited_by, change)
+SELECT id, 1, name, age, gender, diagnosis, created_by, 'snapshot' FROM patients
+WHERE NOT EXISTS (SELECT 1 FROM patient_versions v WHERE v.patient_id = patients.id);
+
+-- Provenance: who registered a patient and when never changes; the last change and the last change of the
+-- diagnosis are tracked separately. Until now created_by held whoever last changed the record, so existing
+-- rows are backfilled once from that and from the revision history.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'patients' AND column_name = 'updated_by') THEN
+        ALTER TABLE patients
+            ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+            ADD COLUMN updated_by UUID REFERENCES users(id) ON DELETE RESTRICT,
+            ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+            ADD COLUMN diagnosed_by UUID REFERENCES users(id) ON DELETE RESTRICT,
+            ADD COLUMN diagnosed_at TIMESTAMPTZ;
+
+        UPDATE patients SET updated_by = created_by;
+
+        -- The first version tells who registered the patient, unless it is the snapshot taken when revision
+        -- history was introduced; then the last editor is the best known registrant and the snapshot time
+        -- the earliest known time.
+        UPDATE patients p SET
+            created_by = CASE WHEN v.change = 'create' THEN v.edited_by ELSE p.created_by END,
+            created_at = v.edited_at
+        FROM patient_versions v
+        WHERE v.patient_id = p.id AND v.version = 1;
+
+        UPDATE patients p SET updated_at = v.edited_at
+        FROM (SELECT DISTINCT ON (patient_id) patient_id, edited_at FROM patient_versions ORDER BY patient_id, version DESC) v
+        WHERE v.patient_id = p.id;
+
+        -- The diagnosis was last set by the latest version that changed it.
+        UPDATE patients p SET diagnosed_by = v.edited_by, diagnosed_at = v.edited_at
+        FROM (
+            SELECT DISTINCT ON (patient_id) patient_id, edited_by, edited_at
+            FROM (
+                SELECT patient_id, version, edited_by, edited_at, diagnosis,
+                    LAG(diagnosis) OVER (PARTITION BY patient_id ORDER BY version) AS previous_diagnosis
+                FROM patient_versions
+            ) changes
+            WHERE diagnosis IS DISTINCT FROM previous_diagnosis
+            ORDER BY patient_id, version DESC
+        ) v
+        WHERE v.patient_id = p.id AND p.diagnosis IS NOT NULL;
+
+        ALTER TABLE patients ALTER COLUMN updated_by SET NOT NULL;
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS patients_created_by_idx ON patients (created_by);
+CREATE INDEX IF NOT EXISTS patients_updated_by_idx ON patients (updated_by);
+CREATE INDEX IF NOT EXISTS patients_diagnosed_by_idx ON patients (diagnosed_by);
+
+-- Optimistic concurrency: every change of a patient record bumps its version, which clients send back in
+-- If-Match. Existing rows start at their latest revision so the two numbers agree.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'patients' AND column_name = 'version') THEN
+        ALTER TABLE patients ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+        UPDATE patients p SET version = v.version
+        FROM (SELECT patient_id, MAX(version) AS version FROM patient_versions GROUP BY patient_id) v
+        WHERE v.patient_id = p.id;
+    END IF;
+END $$;
+
+-- Soft deletion: deleting a patient only marks the record, which is kept (hidden) for the legal retention
+-- period and purged afterwards. Administrators review and restore deleted records.
+ALTER TABLE patients
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS deleted_by UUID REFERENCES users(id) ON DELETE RESTRICT,
+    ADD COLUMN IF NOT EXISTS deletion_reason TEXT;
+
+CREATE INDEX IF NOT EXISTS patients_deleted_at_idx ON patients (deleted_at) WHERE deleted_at IS NOT NULL;
+
+INSERT INTO permissions (name, description) VALUES
+    ('patient:manage_deleted', 'List and restore deleted patient records')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'patient:manage_deleted')
+ON CONFLICT (role, permission) DO NOTHING;
@@ -0,0 +1,2 @@
+-- Removing the permission removes its role assignments too.
+DELETE FROM permissions WHERE name = 'system:read';
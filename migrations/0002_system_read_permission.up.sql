@@ -0,0 +1,9 @@
+-- Operators and monitoring service accounts read the state of the service, such as the statistics of its
+-- database connection pool, without access to patient records or user accounts.
+INSERT INTO permissions (name, description) VALUES
+    ('system:read', 'View the operational state of the service, such as database pool statistics')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'system:read')
+ON CONFLICT (role, permission) DO NOTHING;
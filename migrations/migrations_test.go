@@ -0,0 +1,69 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_wards.up.sql":   {Data: []byte("CREATE TABLE wards (id INT);")},
+		"0002_add_wards.down.sql": {Data: []byte("DROP TABLE wards;")},
+		"0001_initial.up.sql":     {Data: []byte("CREATE TABLE users (id INT);")},
+	}
+
+	migrations, err := Load(fsys)
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+
+	assert.Equal(t, int64(1), migrations[0].Version)
+	assert.Equal(t, "initial", migrations[0].Name)
+	assert.Empty(t, migrations[0].Down)
+	assert.Equal(t, int64(2), migrations[1].Version)
+	assert.Equal(t, "add_wards", migrations[1].Name)
+	assert.Equal(t, "DROP TABLE wards;", migrations[1].Down)
+	assert.Len(t, migrations[1].Checksum, 64)
+	assert.NotEqual(t, migrations[0].Checksum, migrations[1].Checksum)
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, fsys := range map[string]fstest.MapFS{
+			"BadName":      {"initial.up.sql": {Data: []byte("SELECT 1;")}},
+			"ZeroVersion":  {"0000_initial.up.sql": {Data: []byte("SELECT 1;")}},
+			"MissingUp":    {"0001_initial.down.sql": {Data: []byte("SELECT 1;")}},
+			"NameMismatch": {"0001_initial.up.sql": {Data: []byte("SELECT 1;")}, "0001_other.down.sql": {Data: []byte("SELECT 1;")}},
+		} {
+			_, err := Load(fsys)
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load(files)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, mig := range migrations {
+		assert.Equal(t, int64(i+1), mig.Version, "migrations are numbered without gaps")
+		assert.NotEmpty(t, mig.Down, "migration %d_%s can be reverted", mig.Version, mig.Name)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "initial", Checksum: "aaa"},
+		{Version: 2, Name: "add_wards", Checksum: "bbb"},
+	}
+
+	assert.NoError(t, verify(migrations, map[int64]applied{}))
+	assert.NoError(t, verify(migrations, map[int64]applied{1: {Version: 1, Name: "initial", Checksum: "aaa"}}))
+
+	err := verify(migrations, map[int64]applied{1: {Version: 1, Name: "initial", Checksum: "changed"}})
+	assert.ErrorContains(t, err, "was modified")
+
+	err = verify(migrations, map[int64]applied{3: {Version: 3, Name: "from_the_future", Checksum: "ccc"}})
+	assert.ErrorContains(t, err, "unknown to this version")
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// unusablePassword is stored as the password of accounts that do not log in with a password,
+// i.e. service accounts and single sign-on users. It is not a valid hash, so no password ever matches it.
+const unusablePassword = "!"
+
+// APIKey is a long-lived credential of a service account, sent in the X-API-Key header.
+type APIKey struct {
+	ID               string         `json:"id" db:"id"`                                 // Unique identifier for the key.
+	ServiceAccountID string         `json:"service_account_id" db:"service_account_id"` // Service account the key authenticates as.
+	Name             string         `json:"name" db:"name"`                             // What the key is used for, e.g. "lab results import".
+	Prefix           string         `json:"prefix" db:"prefix"`                         // Non-secret start of the key, to recognise it in logs and listings.
+	KeyHash          string         `json:"-" db:"key_hash"`                            // SHA-256 hash of the key, never the key itself.
+	Scopes           []string       `json:"scopes" db:"scopes"`                         // Permissions the key may use; limited by the service account's role.
+	ExpiresAt        sql.NullTime   `json:"expires_at" db:"expires_at"`                 // Time after which the key stops working; null for keys that do not expire.
+	LastUsedAt       sql.NullTime   `json:"last_used_at" db:"last_used_at"`             // Time the key last authenticated a request.
+	RevokedAt        sql.NullTime   `json:"revoked_at" db:"revoked_at"`                 // Set once the key has been revoked.
+	CreatedBy        sql.NullString `json:"created_by" db:"created_by"`                 // Administrator who created the key.
+	CreatedAt        time.Time      `json:"created_at" db:"created_at"`                 // Time the key was created.
+
+	Role     string `json:"-" db:"-"` // Role of the service account, loaded with the key for authentication.
+	Disabled bool   `json:"-" db:"-"` // Whether the service account is disabled, loaded with the key for authentication.
+}
+
+// Usable reports whether the key is neither revoked nor expired at the given time.
+func (k *APIKey) Usable(now time.Time) bool {
+	return !k.RevokedAt.Valid && (!k.ExpiresAt.Valid || now.Before(k.ExpiresAt.Time))
+}
+
+// CreateServiceAccount inserts a service account: a user without a password or email that authenticates with API keys.
+// The email column receives a unique placeholder in the reserved .invalid domain.
+func (s *PostgresStore) CreateServiceAccount(ctx context.Context, u *User) error {
+	query := `INSERT INTO users (name, email, password, role, service_account)
+	VALUES ($1, 'service-' || gen_random_uuid() || '@service-accounts.invalid', $2, $3, true)
+	RETURNING id, email, created_at`
+
+	err := s.db.QueryRowContext(ctx, query, u.Name, unusablePassword, u.Role).Scan(&u.ID, &u.Email, &u.CreatedAt)
+	if err != nil {
+		return userWriteError(err)
+	}
+	u.ServiceAccount = true
+	return nil
+}
+
+// CreateAPIKey persists a new API key for a service account.
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, k *APIKey) error {
+	query := `INSERT INTO api_keys (service_account_id, name, prefix, key_hash, scopes, expires_at, created_by)
+	SELECT id, $2, $3, $4, $5, $6, $7 FROM users WHERE id=$1 AND service_account
+	RETURNING id, created_at`
+
+	err := s.db.QueryRowContext(ctx, query, k.ServiceAccountID, k.Name, k.Prefix, k.KeyHash, pq.Array(k.Scopes), k.ExpiresAt, k.CreatedBy).Scan(&k.ID, &k.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return newError(ErrNotFound, "service account with ID %s not found", k.ServiceAccountID)
+		}
+		return fmt.Errorf("error creating API key: %w", err)
+	}
+	return nil
+}
+
+// ListAPIKeys retrieves the API keys of a service account, newest first, including revoked and expired ones.
+func (s *PostgresStore) ListAPIKeys(ctx context.Context, serviceAccountID string) ([]*APIKey, error) {
+	query := `SELECT id, service_account_id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_by, created_at
+	FROM api_keys WHERE service_account_id=$1 ORDER BY created_at DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, serviceAccountID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var k APIKey
+		err := rows.Scan(&k.ID, &k.ServiceAccountID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt, &k.CreatedBy, &k.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning API key row: %w", err)
+		}
+		keys = append(keys, &k)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its value, together with the role and status of its service account.
+func (s *PostgresStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	query := `SELECT k.id, k.service_account_id, k.name, k.prefix, k.scopes, k.expires_at, k.last_used_at, k.revoked_at,
+		k.created_by, k.created_at, u.role, u.disabled
+	FROM api_keys k JOIN users u ON u.id = k.service_account_id WHERE k.key_hash=$1`
+
+	var k APIKey
+	err := s.db.QueryRowContext(ctx, query, keyHash).Scan(&k.ID, &k.ServiceAccountID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &k.ExpiresAt,
+		&k.LastUsedAt, &k.RevokedAt, &k.CreatedBy, &k.CreatedAt, &k.Role, &k.Disabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, newError(ErrNotFound, "API key not found")
+		}
+		return nil, fmt.Errorf("error fetching API key: %w", err)
+	}
+	return &k, nil
+}
+
+// TouchAPIKey records that an API key was just used. To avoid a write per request,
+// the timestamp is only updated when it is more than a minute old.
+func (s *PostgresStore) TouchAPIKey(ctx context.Context, id string) error {
+	query := `UPDATE api_keys SET last_used_at=now()
+	WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`
+
+	if _, err := s.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("error recording API key use: %w", err)
+	}
+	return nil
+}
+
+// RevokeAPIKey revokes an API key of a service account. Revoking an already revoked key succeeds.
+func (s *PostgresStore) RevokeAPIKey(ctx context.Context, serviceAccountID, keyID string) error {
+	query := `UPDATE api_keys SET revoked_at=COALESCE(revoked_at, now()) WHERE id=$1 AND service_account_id=$2`
+
+	res, err := s.db.ExecContext(ctx, query, keyID, serviceAccountID)
+	if err != nil {
+		return fmt.Errorf("error revoking API key: %w", err)
+	}
+	return expectAffected(res, fmt.Sprintf("API key with ID %s not found", keyID))
+}
@@ -0,0 +1,272 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditActionPatientList                  = "patient.list"
+	AuditActionPatientRead                  = "patient.read"
+	AuditActionPatientExport                = "patient.export"
+	AuditActionPatientCreate                = "patient.create"
+	AuditActionPatientUpdate                = "patient.update"
+	AuditActionPatientDelete                = "patient.delete"
+	AuditActionPatientHistory               = "patient.history"
+	AuditActionPatientRestore               = "patient.restore"
+	AuditActionPatientEmergencyAccess       = "patient.emergency_access"
+	AuditActionPatientUndelete              = "patient.undelete"
+	AuditActionPatientPurge                 = "patient.purge"
+	AuditActionPatientEmergencyAccessReport = "patient.emergency_access_report"
+)
+
+// auditLogLockID is the advisory lock serialising appends to the audit log, so that every event
+// chains onto the one before it.
+const auditLogLockID = 7300114
+
+// AuditEvent is one entry of the append-only audit log of access to and changes of patient records.
+// Each event carries the hash of the one before it, so that altering or removing an event breaks the chain.
+// Events never contain patient data, only which fields were changed.
+type AuditEvent struct {
+	ID         int64     `json:"id" db:"id"`                   // Position in the log.
+	OccurredAt time.Time `json:"occurred_at" db:"occurred_at"` // Time of the event, with microsecond precision.
+	ActorID    string    `json:"actor_id" db:"actor_id"`       // User or service account that acted.
+	ActorRole  string    `json:"actor_role" db:"actor_role"`   // Role of the actor at the time.
+	Action     string    `json:"action" db:"action"`           // What was done, one of the AuditAction constants.
+	PatientID  string    `json:"patient_id" db:"patient_id"`   // Patient concerned; empty for listings.
+	Fields     []string  `json:"fields" db:"fields"`           // Names of the fields set or changed; never their values.
+	Detail     string    `json:"detail" db:"detail"`           // Further context, e.g. the search of a listing and the patients returned.
+	RequestID  string    `json:"request_id" db:"request_id"`   // ID of the HTTP request, as in its X-Request-ID header.
+	IP         string    `json:"ip" db:"ip"`                   // Client address of the request.
+	PrevHash   string    `json:"prev_hash" db:"prev_hash"`     // Hash of the previous event; empty for the first one.
+	Hash       string    `json:"hash" db:"hash"`               // SHA-256 over this event's content and PrevHash.
+}
+
+// AuditFilter narrows down an audit log query. Zero values do not filter.
+type AuditFilter struct {
+	ActorID   string
+	PatientID string
+	Action    string
+	From      time.Time // Only events at or after this time.
+	To        time.Time // Only events before this time.
+}
+
+// AuditVerification is the result of checking the audit log's hash chain.
+type AuditVerification struct {
+	Valid     bool   `json:"valid"`                // Whether every event matches its hash and chains onto the previous one.
+	Events    int    `json:"events"`               // Number of events checked, up to and including the first invalid one.
+	LastHash  string `json:"last_hash"`            // Hash of the last valid event; record it elsewhere to detect truncation later.
+	InvalidID int64  `json:"invalid_id,omitempty"` // ID of the first event that does not verify.
+	Problem   string `json:"problem,omitempty"`    // Why that event does not verify.
+}
+
+// auditEventHash computes the hash of an event from its content and the hash of the previous event.
+func auditEventHash(e *AuditEvent) string {
+	fields := e.Fields
+	if fields == nil {
+		fields = []string{}
+	}
+	// Struct fields marshal in declaration order, which makes the encoding canonical.
+	content, _ := json.Marshal(struct {
+		PrevHash   string   `json:"prev_hash"`
+		OccurredAt string   `json:"occurred_at"`
+		ActorID    string   `json:"actor_id"`
+		ActorRole  string   `json:"actor_role"`
+		Action     string   `json:"action"`
+		PatientID  string   `json:"patient_id"`
+		Fields     []string `json:"fields"`
+		Detail     string   `json:"detail"`
+		RequestID  string   `json:"request_id"`
+		IP         string   `json:"ip"`
+	}{e.PrevHash, e.OccurredAt.UTC().Format(time.RFC3339Nano), e.ActorID, e.ActorRole, e.Action, e.PatientID, fields, e.Detail, e.RequestID, e.IP})
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// AppendAuditEvent adds an event to the end of the audit log, setting its time, ID and hashes.
+func (s *PostgresStore) AppendAuditEvent(ctx context.Context, e *AuditEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := appendAuditEvent(ctx, tx, e); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing audit event: %w", err)
+	}
+	return nil
+}
+
+// AppendAuditEvents adds events to the end of the audit log in order and in a single transaction, so that either
+// all of them are recorded or none is.
+func (s *PostgresStore) AppendAuditEvents(ctx context.Context, events []*AuditEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, e := range events {
+		if err := appendAuditEvent(ctx, tx, e); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing audit events: %w", err)
+	}
+	return nil
+}
+
+// appendAuditEvent adds an event to the end of the audit log within tx, so that changes of patient records are
+// only committed together with the event recording them. The log stays locked until tx ends.
+func appendAuditEvent(ctx context.Context, tx *sql.Tx, e *AuditEvent) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditLogLockID); err != nil {
+		return fmt.Errorf("error locking audit log: %w", err)
+	}
+
+	var prevHash string
+	err := tx.QueryRowContext(ctx, `SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error fetching last audit event: %w", err)
+	}
+
+	// PostgreSQL keeps microseconds, so the hash must not cover more.
+	e.OccurredAt = time.Now().UTC().Truncate(time.Microsecond)
+	e.PrevHash = prevHash
+	e.Hash = auditEventHash(e)
+
+	query := `INSERT INTO audit_log (occurred_at, actor_id, actor_role, action, patient_id, fields, detail, request_id, ip, prev_hash, hash)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	RETURNING id`
+
+	err = tx.QueryRowContext(ctx, query, e.OccurredAt, e.ActorID, e.ActorRole, e.Action, e.PatientID, pq.Array(e.Fields), e.Detail,
+		e.RequestID, e.IP, e.PrevHash, e.Hash).Scan(&e.ID)
+	if err != nil {
+		return fmt.Errorf("error appending audit event: %w", err)
+	}
+	return nil
+}
+
+// ListAuditEvents retrieves a page of audit events, newest first.
+func (s *PostgresStore) ListAuditEvents(ctx context.Context, f AuditFilter, limit, offset int) ([]*AuditEvent, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	addCondition := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(cond, len(args)))
+	}
+	if f.ActorID != "" {
+		addCondition("actor_id=$%d", f.ActorID)
+	}
+	if f.PatientID != "" {
+		addCondition("patient_id=$%d", f.PatientID)
+	}
+	if f.Action != "" {
+		addCondition("action=$%d", f.Action)
+	}
+	if !f.From.IsZero() {
+		addCondition("occurred_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		addCondition("occurred_at < $%d", f.To)
+	}
+
+	query := `SELECT ` + auditEventColumns + ` FROM audit_log`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(` ORDER BY id DESC LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning audit events: %w", err)
+	}
+	return events, nil
+}
+
+// VerifyAuditLog walks the audit log from the start and checks that every event matches its hash
+// and chains onto the previous one. It stops at the first event that does not.
+func (s *PostgresStore) VerifyAuditLog(ctx context.Context) (*AuditVerification, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+auditEventColumns+` FROM audit_log ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching audit events: %w", err)
+	}
+	defer rows.Close()
+
+	result := &AuditVerification{Valid: true}
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		result.Events++
+
+		if problem := verifyAuditEvent(e, result.LastHash); problem != "" {
+			result.Valid = false
+			result.InvalidID = e.ID
+			result.Problem = problem
+			return result, nil
+		}
+		result.LastHash = e.Hash
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning audit events: %w", err)
+	}
+	return result, nil
+}
+
+// verifyAuditEvent returns why an event does not verify against the hash of the event before it, or "".
+func verifyAuditEvent(e *AuditEvent, prevHash string) string {
+	if e.PrevHash != prevHash {
+		return "previous hash does not match the preceding event; events were removed or reordered"
+	}
+	if auditEventHash(e) != e.Hash {
+		return "hash does not match the event's content; the event was altered"
+	}
+	return ""
+}
+
+// auditEventColumns lists the audit_log columns in the order scanAuditEvent expects them.
+const auditEventColumns = `id, occurred_at, actor_id, actor_role, action, patient_id, fields, detail, request_id, ip, prev_hash, hash`
+
+// scanAuditEvent scans a row selected with auditEventColumns.
+func scanAuditEvent(rows *sql.Rows) (*AuditEvent, error) {
+	var e AuditEvent
+	err := rows.Scan(&e.ID, &e.OccurredAt, &e.ActorID, &e.ActorRole, &e.Action, &e.PatientID, pq.Array(&e.Fields),
+		&e.Detail, &e.RequestID, &e.IP, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning audit event row: %w", err)
+	}
+	return &e, nil
+}
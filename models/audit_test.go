@@ -0,0 +1,37 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditEventHashChain(t *testing.T) {
+	at := time.Date(2026, 5, 4, 10, 30, 0, 123456000, time.UTC)
+	first := &AuditEvent{OccurredAt: at, ActorID: "u1", ActorRole: "doctor", Action: AuditActionPatientRead, PatientID: "p1", RequestID: "r1", IP: "10.0.0.1"}
+	first.Hash = auditEventHash(first)
+	second := &AuditEvent{OccurredAt: at.Add(time.Second), ActorID: "u2", ActorRole: "receptionist", Action: AuditActionPatientUpdate,
+		PatientID: "p1", Fields: []string{"name", "age"}, RequestID: "r2", IP: "10.0.0.2", PrevHash: first.Hash}
+	second.Hash = auditEventHash(second)
+
+	assert.Empty(t, verifyAuditEvent(first, ""))
+	assert.Empty(t, verifyAuditEvent(second, first.Hash))
+
+	// The time zone the database returns times in does not matter, nor does a nil versus an empty field list
+	reloaded := *first
+	reloaded.OccurredAt = at.In(time.FixedZone("CEST", 2*60*60))
+	reloaded.Fields = []string{}
+	assert.Empty(t, verifyAuditEvent(&reloaded, ""))
+
+	// Altering any content breaks the event's own hash
+	altered := *second
+	altered.Fields = []string{"name", "age", "diagnosis"}
+	assert.Contains(t, verifyAuditEvent(&altered, first.Hash), "altered")
+	altered = *second
+	altered.ActorID = "u3"
+	assert.Contains(t, verifyAuditEvent(&altered, first.Hash), "altered")
+
+	// Removing an event breaks the link of the one after it
+	assert.Contains(t, verifyAuditEvent(second, ""), "removed")
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CareTeamMember assigns a clinician to a patient's care team for a period of time.
+// Callers without patient:read_all only see the patients on whose current care team they are.
+type CareTeamMember struct {
+	ID         string         `json:"id" db:"id"`                   // Unique identifier for the assignment.
+	PatientID  string         `json:"patient_id" db:"patient_id"`   // Patient being cared for.
+	UserID     string         `json:"user_id" db:"user_id"`         // Clinician assigned to the patient.
+	Role       string         `json:"role" db:"role"`               // Role on the care team (e.g. "attending physician"); unrelated to the user's role.
+	StartsOn   time.Time      `json:"starts_on" db:"starts_on"`     // First day of the assignment.
+	EndsOn     sql.NullTime   `json:"ends_on" db:"ends_on"`         // Last day of the assignment; null while open-ended.
+	AssignedBy sql.NullString `json:"assigned_by" db:"assigned_by"` // User who made the assignment; null once that account is deleted.
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`   // Time the assignment was made.
+
+	UserName string `json:"user_name,omitempty" db:"-"` // Name of the clinician, loaded when listing a care team.
+	Active   bool   `json:"active" db:"-"`              // Whether the assignment currently gives access, loaded when listing a care team.
+}
+
+// PatientFilter narrows down a patient listing. Zero values do not filter.
+type PatientFilter struct {
+	Name             string // Case-insensitive partial match on the patient's name.
+	CareTeamMemberID string // Only patients on whose current care team this user is.
+
+	CreatedBy   string    // Only patients registered by this user.
+	UpdatedBy   string    // Only patients last changed by this user.
+	DiagnosedBy string    // Only patients whose diagnosis this user last set.
+	CreatedFrom time.Time // Only patients registered at or after this time.
+	CreatedTo   time.Time // Only patients registered before this time.
+	UpdatedFrom time.Time // Only patients last changed at or after this time.
+	UpdatedTo   time.Time // Only patients last changed before this time.
+}
+
+// activeCareTeamCondition restricts care_team_members rows to assignments covering today.
+const activeCareTeamCondition = `starts_on <= CURRENT_DATE AND (ends_on IS NULL OR ends_on >= CURRENT_DATE)`
+
+// AddCareTeamMember persists a new care team assignment.
+func (s *PostgresStore) AddCareTeamMember(ctx context.Context, m *CareTeamMember) error {
+	query := `INSERT INTO care_team_members (patient_id, user_id, role, starts_on, ends_on, assigned_by)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
+
+	err := s.db.QueryRowContext(ctx, query, m.PatientID, m.UserID, m.Role, m.StartsOn, m.EndsOn, m.AssignedBy).Scan(&m.ID, &m.CreatedAt)
+	if err != nil {
+		return careTeamWriteError(err, m)
+	}
+	return nil
+}
+
+// ListCareTeam retrieves every assignment to a patient's care team, current and past, starting with the most recent.
+func (s *PostgresStore) ListCareTeam(ctx context.Context, patientID string) ([]*CareTeamMember, error) {
+	query := `SELECT m.id, m.patient_id, m.user_id, m.role, m.starts_on, m.ends_on, m.assigned_by, m.created_at,
+		COALESCE(u.name, ''), (m.` + activeCareTeamCondition + `)
+	FROM care_team_members m LEFT JOIN users u ON u.id = m.user_id
+	WHERE m.patient_id=$1
+	ORDER BY m.starts_on DESC, m.created_at DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, patientID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching care team: %w", err)
+	}
+	defer rows.Close()
+
+	members := []*CareTeamMember{}
+	for rows.Next() {
+		var m CareTeamMember
+		err := rows.Scan(&m.ID, &m.PatientID, &m.UserID, &m.Role, &m.StartsOn, &m.EndsOn, &m.AssignedBy, &m.CreatedAt, &m.UserName, &m.Active)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning care team row: %w", err)
+		}
+		members = append(members, &m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning care team: %w", err)
+	}
+	return members, nil
+}
+
+// UpdateCareTeamMember changes the role and dates of an assignment, e.g. to end it while keeping its history.
+func (s *PostgresStore) UpdateCareTeamMember(ctx context.Context, m *CareTeamMember) error {
+	query := `UPDATE care_team_members SET role=$1, starts_on=$2, ends_on=$3 WHERE id=$4 AND patient_id=$5`
+
+	res, err := s.db.ExecContext(ctx, query, m.Role, m.StartsOn, m.EndsOn, m.ID, m.PatientID)
+	if err != nil {
+		return careTeamWriteError(err, m)
+	}
+	return expectAffected(res, fmt.Sprintf("care team assignment with ID %s not found", m.ID))
+}
+
+// RemoveCareTeamMember deletes an assignment, e.g. one made by mistake.
+func (s *PostgresStore) RemoveCareTeamMember(ctx context.Context, patientID, memberID string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM care_team_members WHERE id=$1 AND patient_id=$2`, memberID, patientID)
+	if err != nil {
+		return fmt.Errorf("error removing care team assignment: %w", err)
+	}
+	return expectAffected(res, fmt.Sprintf("care team assignment with ID %s not found", memberID))
+}
+
+// IsOnCareTeam reports whether a user is currently on a patient's care team.
+func (s *PostgresStore) IsOnCareTeam(ctx context.Context, userID, patientID string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM care_team_members
+	WHERE user_id=$1 AND patient_id=$2 AND ` + activeCareTeamCondition + `)`
+
+	var onTeam bool
+	if err := s.db.QueryRowContext(ctx, query, userID, patientID).Scan(&onTeam); err != nil {
+		return false, fmt.Errorf("error checking care team membership: %w", err)
+	}
+	return onTeam, nil
+}
+
+// careTeamWriteError translates constraint violations from writing a care team assignment into readable errors.
+func careTeamWriteError(err error, m *CareTeamMember) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code == pqForeignKeyViolation && pqErr.Constraint == "care_team_members_patient_id_fkey":
+			return &Error{Kind: ErrNotFound, Message: fmt.Sprintf("patient with ID %s not found", m.PatientID), Err: err}
+		case pqErr.Code == pqForeignKeyViolation && pqErr.Constraint == "care_team_members_user_id_fkey":
+			return &Error{Kind: ErrNotFound, Message: fmt.Sprintf("user with ID %s not found", m.UserID), Err: err}
+		case pqErr.Code == pqCheckViolation:
+			return &Error{Kind: ErrInvalidInput, Message: "care team assignment cannot end before it starts", Err: err}
+		}
+	}
+	return fmt.Errorf("error writing care team assignment: %w", err)
+}
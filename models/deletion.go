@@ -0,0 +1,112 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ListDeletedPatients retrieves a page of deleted patient records, most recently deleted first.
+func (s *PostgresStore) ListDeletedPatients(ctx context.Context, limit, offset int) ([]*Patient, error) {
+	query := `SELECT ` + patientColumns + ` FROM patients WHERE deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC, id ASC LIMIT $1 OFFSET $2`
+
+	rows, err := s.db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching deleted patients: %w", err)
+	}
+	defer rows.Close()
+
+	patients := []*Patient{}
+	for rows.Next() {
+		var p Patient
+		if err := rows.Scan(patientFields(&p)...); err != nil {
+			return nil, fmt.Errorf("error scanning deleted patient row: %w", err)
+		}
+		patients = append(patients, &p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning deleted patients: %w", err)
+	}
+	return patients, nil
+}
+
+// UndeletePatient brings a deleted patient record back into use, edited by editorID, and records that as a new version.
+func (s *PostgresStore) UndeletePatient(ctx context.Context, id, editorID string, audit *AuditEvent) (*Patient, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE patients SET deleted_at=NULL, deleted_by=NULL, deletion_reason=NULL,
+		version=version+1, updated_by=$2, updated_at=now()
+	WHERE id=$1 AND deleted_at IS NOT NULL
+	RETURNING ` + patientColumns
+
+	var p Patient
+	err = tx.QueryRowContext(ctx, query, id, editorID).Scan(patientFields(&p)...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, newError(ErrNotFound, "deleted patient with ID %s not found", id)
+		}
+		return nil, fmt.Errorf("error restoring deleted patient: %w", err)
+	}
+
+	if err := insertPatientVersion(ctx, tx, &p, editorID, PatientChangeUndelete, sql.NullInt64{}); err != nil {
+		return nil, err
+	}
+	if err := appendAuditEvent(ctx, tx, audit); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing undelete: %w", err)
+	}
+	return &p, nil
+}
+
+// PurgeDeletedPatients permanently removes the patient records deleted before deletedBefore, together with
+// their revision history and care team assignments, and returns their IDs. Records that are not deleted are
+// never purged; the audit log keeps every event about purged records. A copy of audit is recorded for every
+// purged record, with its patient ID, in the same transaction, so no record is purged without being recorded.
+func (s *PostgresStore) PurgeDeletedPatients(ctx context.Context, deletedBefore time.Time, audit *AuditEvent) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `DELETE FROM patients WHERE deleted_at < $1 RETURNING id`, deletedBefore)
+	if err != nil {
+		return nil, fmt.Errorf("error purging deleted patients: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning purged patient ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after purging deleted patients: %w", err)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		event := *audit
+		event.PatientID = id
+		if err := appendAuditEvent(ctx, tx, &event); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing purge: %w", err)
+	}
+	return ids, nil
+}
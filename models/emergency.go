@@ -0,0 +1,150 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EmergencyAccess is a "break the glass" grant: time-boxed access to one patient record for a user
+// whose permissions would not normally allow it, given in exchange for a written justification.
+// Grants are never deleted; together with their recorded uses they form the audit trail for compliance review.
+type EmergencyAccess struct {
+	ID        string    `json:"id" db:"id"`                 // Unique identifier for the grant.
+	UserID    string    `json:"user_id" db:"user_id"`       // User who broke the glass.
+	PatientID string    `json:"patient_id" db:"patient_id"` // Patient the grant is limited to.
+	Reason    string    `json:"reason" db:"reason"`         // Justification given by the user; mandatory.
+	IP        string    `json:"ip" db:"ip"`                 // Client address the grant was requested from.
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"` // Time after which the grant no longer gives access.
+	CreatedAt time.Time `json:"created_at" db:"created_at"` // Time the glass was broken.
+
+	UserName    string       `json:"user_name,omitempty" db:"-"`    // Name of the user, loaded for reports.
+	UserRole    string       `json:"user_role,omitempty" db:"-"`    // Role of the user, loaded for reports.
+	PatientName string       `json:"patient_name,omitempty" db:"-"` // Name of the patient, loaded for reports.
+	Uses        int          `json:"uses" db:"-"`                   // Number of requests made under the grant, loaded for reports.
+	LastUsedAt  sql.NullTime `json:"last_used_at" db:"-"`           // Time of the last request made under the grant, loaded for reports.
+}
+
+// EmergencyAccessFilter narrows down an emergency access report. Zero values do not filter.
+type EmergencyAccessFilter struct {
+	UserID    string
+	PatientID string
+	From      time.Time // Only grants created at or after this time.
+	To        time.Time // Only grants created before this time.
+}
+
+// CreateEmergencyAccess persists a new emergency access grant together with the audit event recording it,
+// whose detail names the grant.
+func (s *PostgresStore) CreateEmergencyAccess(ctx context.Context, g *EmergencyAccess, audit *AuditEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO emergency_access_grants (user_id, patient_id, reason, ip, expires_at)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, g.UserID, g.PatientID, g.Reason, g.IP, g.ExpiresAt).Scan(&g.ID, &g.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error creating emergency access grant: %w", err)
+	}
+
+	audit.Detail = "emergency access grant " + g.ID
+	if err := appendAuditEvent(ctx, tx, audit); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing emergency access grant: %w", err)
+	}
+	return nil
+}
+
+// GetActiveEmergencyAccess retrieves the most recent unexpired emergency access grant of a user for a patient.
+func (s *PostgresStore) GetActiveEmergencyAccess(ctx context.Context, userID, patientID string) (*EmergencyAccess, error) {
+	query := `SELECT id, user_id, patient_id, reason, ip, expires_at, created_at FROM emergency_access_grants
+	WHERE user_id=$1 AND patient_id=$2 AND expires_at > now()
+	ORDER BY expires_at DESC LIMIT 1`
+
+	var g EmergencyAccess
+	err := s.db.QueryRowContext(ctx, query, userID, patientID).Scan(&g.ID, &g.UserID, &g.PatientID, &g.Reason, &g.IP, &g.ExpiresAt, &g.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, newError(ErrNotFound, "emergency access grant not found")
+		}
+		return nil, fmt.Errorf("error fetching emergency access grant: %w", err)
+	}
+	return &g, nil
+}
+
+// RecordEmergencyAccessUse records a request made under an emergency access grant, e.g. "GET /api/patients/<id>".
+func (s *PostgresStore) RecordEmergencyAccessUse(ctx context.Context, grantID, action string) error {
+	query := `INSERT INTO emergency_access_uses (grant_id, action) VALUES ($1, $2)`
+	if _, err := s.db.ExecContext(ctx, query, grantID, action); err != nil {
+		return fmt.Errorf("error recording emergency access use: %w", err)
+	}
+	return nil
+}
+
+// ListEmergencyAccesses retrieves a page of emergency access grants, newest first, with the names of the
+// user and patient and a summary of how each grant was used.
+func (s *PostgresStore) ListEmergencyAccesses(ctx context.Context, f EmergencyAccessFilter, limit, offset int) ([]*EmergencyAccess, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	addCondition := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(cond, len(args)))
+	}
+	if f.UserID != "" {
+		addCondition("g.user_id=$%d", f.UserID)
+	}
+	if f.PatientID != "" {
+		addCondition("g.patient_id=$%d", f.PatientID)
+	}
+	if !f.From.IsZero() {
+		addCondition("g.created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		addCondition("g.created_at < $%d", f.To)
+	}
+
+	query := `SELECT g.id, g.user_id, g.patient_id, g.reason, g.ip, g.expires_at, g.created_at,
+		COALESCE(u.name, ''), COALESCE(u.role, ''), COALESCE(p.name, ''), COUNT(a.id), MAX(a.used_at)
+	FROM emergency_access_grants g
+	LEFT JOIN users u ON u.id = g.user_id
+	LEFT JOIN patients p ON p.id = g.patient_id
+	LEFT JOIN emergency_access_uses a ON a.grant_id = g.id`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(` GROUP BY g.id, u.name, u.role, p.name
+	ORDER BY g.created_at DESC, g.id ASC LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching emergency access grants: %w", err)
+	}
+	defer rows.Close()
+
+	grants := []*EmergencyAccess{}
+	for rows.Next() {
+		var g EmergencyAccess
+		err := rows.Scan(&g.ID, &g.UserID, &g.PatientID, &g.Reason, &g.IP, &g.ExpiresAt, &g.CreatedAt,
+			&g.UserName, &g.UserRole, &g.PatientName, &g.Uses, &g.LastUsedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning emergency access grant row: %w", err)
+		}
+		grants = append(grants, &g)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning rows: %w", err)
+	}
+	return grants, nil
+}
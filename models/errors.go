@@ -0,0 +1,97 @@
+package models
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Kinds of storage errors. Errors caused by the request rather than by the database, and errors reporting that
+// the database is unavailable, match one of these with errors.Is, so that callers never need to inspect error
+// messages.
+var (
+	ErrNotFound           = errors.New("not found")            // The record does not exist, or is hidden from the caller (e.g. deleted).
+	ErrConflict           = errors.New("conflict")             // The change conflicts with the current state, e.g. a token used twice.
+	ErrStaleVersion       = errors.New("stale version")        // A conditional write targeted a version of a record that is no longer current.
+	ErrDuplicateEmail     = errors.New("duplicate email")      // Another account already uses the email address.
+	ErrConstraint         = errors.New("constraint violation") // The change would break a database constraint, e.g. a reference that is in use.
+	ErrInvalidInput       = errors.New("invalid input")        // A value is malformed, out of range or refers to nothing.
+	ErrInvalidCredentials = errors.New("invalid credentials")  // The email and password do not match an account.
+	ErrAccountDisabled    = errors.New("account disabled")     // The account exists but may not be used.
+	ErrUnavailable        = errors.New("database unavailable") // The database cannot serve requests right now; retrying later may succeed.
+)
+
+// PostgreSQL error codes translated into error kinds.
+const (
+	pqUniqueViolation       = "23505"
+	pqForeignKeyViolation   = "23503"
+	pqCheckViolation        = "23514"
+	pqNotNullViolation      = "23502"
+	pqInvalidText           = "22P02"
+	pqStringTooLong         = "22001"
+	pqNumericOutOfRange     = "22003"
+	pqInvalidDatetimeFormat = "22007"
+	pqTooManyConnections    = "53300"
+	pqAdminShutdown         = "57P01"
+	pqCrashShutdown         = "57P02"
+	pqCannotConnectNow      = "57P03"
+)
+
+// Error is a storage error of one of the kinds above. Its message is meant for API clients and never
+// contains database internals; the underlying database error, if any, is kept for logging.
+type Error struct {
+	Kind    error  // One of the Err* kinds.
+	Message string // Description of the problem for the caller.
+	Err     error  // Underlying cause; nil when the store detected the problem itself.
+}
+
+// Error returns the message of e.
+func (e *Error) Error() string { return e.Message }
+
+// Is reports whether e is of the given kind.
+func (e *Error) Is(target error) bool { return target == e.Kind }
+
+// Unwrap returns the underlying cause of e.
+func (e *Error) Unwrap() error { return e.Err }
+
+// newError returns an error of the given kind with a formatted message.
+func newError(kind error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// Kind classifies err: it returns the Err* kind err matches, translating PostgreSQL errors reported by lib/pq
+// and sql.ErrNoRows wrapped anywhere in err, or nil when err says nothing about the request or the database's
+// availability (e.g. a query timed out).
+func Kind(err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return ErrUnavailable
+	}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return nil
+	}
+	switch pqErr.Code {
+	case pqUniqueViolation:
+		if pqErr.Constraint == "users_email_key" {
+			return ErrDuplicateEmail
+		}
+		return ErrConflict
+	case pqForeignKeyViolation, pqCheckViolation, pqNotNullViolation:
+		return ErrConstraint
+	case pqInvalidText, pqStringTooLong, pqNumericOutOfRange, pqInvalidDatetimeFormat:
+		return ErrInvalidInput
+	case pqTooManyConnections, pqAdminShutdown, pqCrashShutdown, pqCannotConnectNow:
+		return ErrUnavailable
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"StorageError", newError(ErrStaleVersion, "version conflict: patient p1 is at version 2"), ErrStaleVersion},
+		{"WrappedStorageError", fmt.Errorf("restoring: %w", newError(ErrNotFound, "patient with ID p1 not found")), ErrNotFound},
+		{"NoRows", fmt.Errorf("error fetching user: %w", sql.ErrNoRows), ErrNotFound},
+		{"DuplicateEmail", &pq.Error{Code: pqUniqueViolation, Constraint: "users_email_key"}, ErrDuplicateEmail},
+		{"OtherUniqueViolation", &pq.Error{Code: pqUniqueViolation, Constraint: "care_team_members_pkey"}, ErrConflict},
+		{"ForeignKeyViolation", fmt.Errorf("error deleting user: %w", &pq.Error{Code: pqForeignKeyViolation}), ErrConstraint},
+		{"InvalidText", &pq.Error{Code: pqInvalidText}, ErrInvalidInput},
+		{"TooManyConnections", &pq.Error{Code: pqTooManyConnections}, ErrUnavailable},
+		{"BadConnection", fmt.Errorf("error fetching patients: %w", driver.ErrBadConn), ErrUnavailable},
+		{"UnclassifiedDatabaseError", &pq.Error{Code: "XX000"}, nil},
+		{"OtherError", errors.New("connection refused"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Kind(tt.err))
+		})
+	}
+
+	err := &Error{Kind: ErrInvalidInput, Message: "role does not exist", Err: &pq.Error{Code: pqForeignKeyViolation}}
+	assert.True(t, errors.Is(err, ErrInvalidInput))
+	assert.False(t, errors.Is(err, ErrConstraint))
+	assert.Equal(t, "role does not exist", err.Error())
+}
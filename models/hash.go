@@ -0,0 +1,138 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher creates password hashes for storage.
+// Hashes produced by any supported algorithm can be verified regardless of the configured hasher
+// (see checkPassword), so switching algorithms only affects new hashes; old ones are upgraded at login.
+type PasswordHasher interface {
+	// Hash returns an encoded hash of password including its algorithm and parameters.
+	Hash(password string) (string, error)
+	// NeedsRehash reports whether hash was created with another algorithm or weaker parameters than this hasher uses.
+	NeedsRehash(hash string) bool
+}
+
+// NewPasswordHasher returns the hasher for the named algorithm: "bcrypt" (the default when name is empty) or "argon2id".
+// bcryptCost is only used by bcrypt; values outside bcrypt's range fall back to bcrypt.DefaultCost.
+func NewPasswordHasher(name string, bcryptCost int) (PasswordHasher, error) {
+	switch strings.ToLower(name) {
+	case "", "bcrypt":
+		if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+			bcryptCost = bcrypt.DefaultCost
+		}
+		return BcryptHasher{Cost: bcryptCost}, nil
+	case "argon2id":
+		return DefaultArgon2idHasher(), nil
+	}
+	return nil, fmt.Errorf("unknown password hasher %q", name)
+}
+
+// BcryptHasher hashes passwords with bcrypt.
+type BcryptHasher struct {
+	Cost int // bcrypt work factor; each increment doubles the time needed per hash.
+}
+
+// Hash returns the bcrypt hash of password.
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt hashing failed: %w", err)
+	}
+	return string(hashed), nil
+}
+
+// NeedsRehash reports whether hash is not a bcrypt hash or uses a lower cost than h.
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.Cost
+}
+
+// argon2idPrefix starts every hash in the PHC string format produced by Argon2idHasher.
+const argon2idPrefix = "$argon2id$"
+
+// Argon2idHasher hashes passwords with argon2id, encoded in the PHC string format
+// ($argon2id$v=19$m=<KiB>,t=<iterations>,p=<threads>$<salt>$<hash>).
+type Argon2idHasher struct {
+	Time    uint32 // Number of passes over the memory.
+	Memory  uint32 // Memory used in KiB.
+	Threads uint8  // Degree of parallelism.
+	KeyLen  uint32 // Length of the derived key in bytes.
+	SaltLen uint32 // Length of the random salt in bytes.
+}
+
+// DefaultArgon2idHasher returns an argon2id hasher with the parameters recommended by RFC 9106 for memory-constrained servers.
+func DefaultArgon2idHasher() Argon2idHasher {
+	return Argon2idHasher{Time: 3, Memory: 64 * 1024, Threads: 4, KeyLen: 32, SaltLen: 16}
+}
+
+// Hash returns the argon2id hash of password with a new random salt.
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// NeedsRehash reports whether hash is not an argon2id hash or was created with different parameters than h.
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.Time != h.Time || params.Memory != h.Memory || params.Threads != h.Threads ||
+		uint32(len(salt)) != h.SaltLen || uint32(len(key)) != h.KeyLen
+}
+
+// decodeArgon2id parses an argon2id hash in PHC string format.
+func decodeArgon2id(hash string) (params Argon2idHasher, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// checkPassword compares a plaintext password with a stored hash of any supported algorithm.
+func checkPassword(hashedPassword, password string) bool {
+	if strings.HasPrefix(hashedPassword, argon2idPrefix) {
+		params, salt, key, err := decodeArgon2id(hashedPassword)
+		if err != nil {
+			return false
+		}
+		candidate := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+		return subtle.ConstantTimeCompare(candidate, key) == 1
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	return err == nil
+}
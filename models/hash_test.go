@@ -0,0 +1,67 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBcryptHasher(t *testing.T) {
+	h := BcryptHasher{Cost: bcrypt.MinCost + 1}
+
+	hashed, err := h.Hash("correct horse")
+	require.NoError(t, err)
+	assert.True(t, checkPassword(hashed, "correct horse"))
+	assert.False(t, checkPassword(hashed, "wrong horse"))
+	assert.False(t, h.NeedsRehash(hashed))
+
+	// A lower cost than configured is upgraded, a higher one is kept
+	weaker, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("correct horse")
+	require.NoError(t, err)
+	assert.True(t, h.NeedsRehash(weaker))
+	assert.False(t, BcryptHasher{Cost: bcrypt.MinCost}.NeedsRehash(hashed))
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	h := Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+
+	hashed, err := h.Hash("correct horse")
+	require.NoError(t, err)
+	assert.Regexp(t, `^\$argon2id\$v=19\$m=1024,t=1,p=1\$[^$]+\$[^$]+$`, hashed)
+	assert.True(t, checkPassword(hashed, "correct horse"))
+	assert.False(t, checkPassword(hashed, "wrong horse"))
+	assert.False(t, h.NeedsRehash(hashed))
+
+	// Changed parameters or another algorithm need a rehash
+	stronger := h
+	stronger.Time = 2
+	assert.True(t, stronger.NeedsRehash(hashed))
+
+	bcryptHash, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("correct horse")
+	require.NoError(t, err)
+	assert.True(t, h.NeedsRehash(bcryptHash))
+	assert.True(t, BcryptHasher{Cost: bcrypt.MinCost}.NeedsRehash(hashed))
+
+	// bcrypt hashes still verify while argon2id is configured
+	assert.True(t, checkPassword(bcryptHash, "correct horse"))
+	assert.False(t, checkPassword("$argon2id$garbage", "correct horse"))
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	h, err := NewPasswordHasher("", 0)
+	require.NoError(t, err)
+	assert.Equal(t, BcryptHasher{Cost: bcrypt.DefaultCost}, h)
+
+	h, err = NewPasswordHasher("bcrypt", 12)
+	require.NoError(t, err)
+	assert.Equal(t, BcryptHasher{Cost: 12}, h)
+
+	h, err = NewPasswordHasher("Argon2id", 0)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultArgon2idHasher(), h)
+
+	_, err = NewPasswordHasher("md5", 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Kinds of change that produce a patient version.
+const (
+	PatientChangeCreate   = "create"   // The patient was registered.
+	PatientChangeUpdate   = "update"   // Demographics or the diagnosis were edited.
+	PatientChangeRestore  = "restore"  // An earlier version was restored.
+	PatientChangeSnapshot = "snapshot" // The record as it was when revision history was introduced.
+	PatientChangeDelete   = "delete"   // The record was deleted.
+	PatientChangeUndelete = "undelete" // A deleted record was restored.
+)
+
+// PatientVersion is one revision of a patient record. Every change of a patient adds a version;
+// versions are never changed, so together they hold every value the record ever had.
+type PatientVersion struct {
+	PatientID    string         `json:"patient_id" db:"patient_id"`       // Patient the version belongs to.
+	Version      int            `json:"version" db:"version"`             // Revision number, counting from 1; matches Patient.Version.
+	Name         string         `json:"name" db:"name"`                   // Name of the patient in this version.
+	Age          uint           `json:"age" db:"age"`                     // Age of the patient in this version.
+	Gender       string         `json:"gender" db:"gender"`               // Gender of the patient in this version.
+	Diagnosis    sql.NullString `json:"diagnosis" db:"diagnosis"`         // Diagnosis in this version, can be null.
+	EditedBy     string         `json:"edited_by" db:"edited_by"`         // User who made the change.
+	EditedAt     time.Time      `json:"edited_at" db:"edited_at"`         // Time of the change.
+	Change       string         `json:"change" db:"change"`               // Kind of change, one of the PatientChange constants.
+	RestoredFrom sql.NullInt64  `json:"restored_from" db:"restored_from"` // Version restored, for restores.
+}
+
+// insertPatientVersion records the current state of p as version p.Version of the patient.
+// It must run in the transaction that changed the patient, after the patient row was written (and so locked).
+func insertPatientVersion(ctx context.Context, tx *sql.Tx, p *Patient, editorID, change string, restoredFrom sql.NullInt64) error {
+	query := `INSERT INTO patient_versions (patient_id, version, name, age, gender, diagnosis, edited_by, change, restored_from)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	_, err := tx.ExecContext(ctx, query, p.ID, p.Version, p.Name, p.Age, p.Gender, p.Diagnosis, editorID, change, restoredFrom)
+	if err != nil {
+		return fmt.Errorf("error recording patient version: %w", err)
+	}
+	return nil
+}
+
+// ListPatientVersions retrieves every version of a patient record, newest first. Deleted records have none.
+func (s *PostgresStore) ListPatientVersions(ctx context.Context, patientID string) ([]*PatientVersion, error) {
+	query := `SELECT ` + patientVersionColumns + ` FROM patient_versions WHERE patient_id=$1 AND ` + livePatientVersionCondition + `
+	ORDER BY version DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, patientID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching patient versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []*PatientVersion{}
+	for rows.Next() {
+		var v PatientVersion
+		if err := rows.Scan(&v.PatientID, &v.Version, &v.Name, &v.Age, &v.Gender, &v.Diagnosis, &v.EditedBy, &v.EditedAt, &v.Change, &v.RestoredFrom); err != nil {
+			return nil, fmt.Errorf("error scanning patient version row: %w", err)
+		}
+		versions = append(versions, &v)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning patient versions: %w", err)
+	}
+	return versions, nil
+}
+
+// GetPatientVersion retrieves one version of a patient record that is not deleted.
+func (s *PostgresStore) GetPatientVersion(ctx context.Context, patientID string, version int) (*PatientVersion, error) {
+	query := `SELECT ` + patientVersionColumns + ` FROM patient_versions WHERE patient_id=$1 AND version=$2 AND ` + livePatientVersionCondition
+
+	var v PatientVersion
+	err := s.db.QueryRowContext(ctx, query, patientID, version).Scan(&v.PatientID, &v.Version, &v.Name, &v.Age, &v.Gender, &v.Diagnosis, &v.EditedBy, &v.EditedAt, &v.Change, &v.RestoredFrom)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, newError(ErrNotFound, "version %d of patient %s not found", version, patientID)
+		}
+		return nil, fmt.Errorf("error fetching patient version: %w", err)
+	}
+	return &v, nil
+}
+
+// RestorePatientVersion sets a patient record back to the values of an earlier version.
+// The restore is itself recorded as a new version, so it can be undone the same way. It only applies while the
+// record is still at the expected version; otherwise it fails with a version conflict.
+func (s *PostgresStore) RestorePatientVersion(ctx context.Context, patientID string, version, expected int, editorID string, audit *AuditEvent) (*Patient, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The version's columns are renamed so that patientColumns stay unambiguous in RETURNING.
+	query := `UPDATE patients SET name=v.v_name, age=v.v_age, gender=v.v_gender, diagnosis=v.v_diagnosis,
+		version=version+1, updated_by=$3, updated_at=now(),
+		diagnosed_by = CASE WHEN diagnosis IS DISTINCT FROM v.v_diagnosis THEN $3 ELSE diagnosed_by END,
+		diagnosed_at = CASE WHEN diagnosis IS DISTINCT FROM v.v_diagnosis THEN now() ELSE diagnosed_at END
+	FROM (SELECT patient_id AS v_patient_id, name AS v_name, age AS v_age, gender AS v_gender, diagnosis AS v_diagnosis
+		FROM patient_versions WHERE patient_id=$1 AND version=$2) v
+	WHERE id=v.v_patient_id AND version=$4 AND deleted_at IS NULL
+	RETURNING ` + patientColumns
+
+	var p Patient
+	err = tx.QueryRowContext(ctx, query, patientID, version, editorID, expected).Scan(patientFields(&p)...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			var exists bool
+			err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM patient_versions WHERE patient_id=$1 AND version=$2)`, patientID, version).Scan(&exists)
+			if err != nil {
+				return nil, fmt.Errorf("error checking patient version: %w", err)
+			}
+			if !exists {
+				return nil, newError(ErrNotFound, "version %d of patient %s not found", version, patientID)
+			}
+			return nil, patientWriteConflict(ctx, tx, patientID, "patient with ID %s not found for restore")
+		}
+		return nil, fmt.Errorf("error restoring patient version: %w", err)
+	}
+
+	if err := insertPatientVersion(ctx, tx, &p, editorID, PatientChangeRestore, sql.NullInt64{Int64: int64(version), Valid: true}); err != nil {
+		return nil, err
+	}
+	if err := appendAuditEvent(ctx, tx, audit); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing restore: %w", err)
+	}
+	return &p, nil
+}
+
+// livePatientVersionCondition limits a patient_versions query to the versions of records that are not deleted.
+const livePatientVersionCondition = `patient_id IN (SELECT id FROM patients WHERE deleted_at IS NULL)`
+
+// patientVersionColumns lists the patient_versions columns in the order they are scanned.
+const patientVersionColumns = `patient_id, version, name, age, gender, diagnosis, edited_by, edited_at, change, restored_from`
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// TOTPConfig holds a user's TOTP enrolment state. It is kept out of User so the secret is never serialised by accident.
+type TOTPConfig struct {
+	Secret   string        `json:"-" db:"totp_secret"`        // Base32 TOTP secret; empty when the user has not started enrolment.
+	Enabled  bool          `json:"enabled" db:"totp_enabled"` // Set once the user has confirmed enrolment with a valid code.
+	LastStep sql.NullInt64 `json:"-" db:"totp_last_step"`     // Time step of the last accepted code.
+}
+
+// GetTOTPConfig retrieves the TOTP enrolment state of a user.
+func (s *PostgresStore) GetTOTPConfig(ctx context.Context, userID string) (*TOTPConfig, error) {
+	query := `SELECT COALESCE(totp_secret, ''), totp_enabled, totp_last_step FROM users WHERE id=$1`
+
+	var cfg TOTPConfig
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&cfg.Secret, &cfg.Enabled, &cfg.LastStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, newError(ErrNotFound, "user with ID %s not found", userID)
+		}
+		return nil, fmt.Errorf("error fetching TOTP configuration: %w", err)
+	}
+	return &cfg, nil
+}
+
+// SetPendingTOTPSecret stores a new, not yet confirmed TOTP secret for a user.
+// It fails when the user already has TOTP enabled; enrolment must be disabled first.
+func (s *PostgresStore) SetPendingTOTPSecret(ctx context.Context, userID, secret string) error {
+	query := `UPDATE users SET totp_secret=$1, totp_last_step=NULL WHERE id=$2 AND NOT totp_enabled`
+
+	res, err := s.db.ExecContext(ctx, query, secret, userID)
+	if err != nil {
+		return fmt.Errorf("error storing TOTP secret: %w", err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return newError(ErrConflict, "two-factor authentication is already enabled for user %s", userID)
+	}
+	return nil
+}
+
+// EnableTOTP confirms a user's pending TOTP secret and replaces their recovery codes.
+// step is the time step of the code used for confirmation, so that code cannot be used again to log in.
+func (s *PostgresStore) EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting TOTP enrolment: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users SET totp_enabled=true, totp_last_step=$1
+	WHERE id=$2 AND totp_secret IS NOT NULL AND NOT totp_enabled`
+	res, err := tx.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return fmt.Errorf("error enabling TOTP: %w", err)
+	}
+	if err := expectAffected(res, fmt.Sprintf("no pending TOTP enrolment for user %s", userID)); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return fmt.Errorf("error removing old recovery codes: %w", err)
+	}
+
+	insert := `INSERT INTO recovery_codes (user_id, code_hash) SELECT $1, unnest($2::text[])`
+	if _, err := tx.ExecContext(ctx, insert, userID, pq.Array(recoveryCodeHashes)); err != nil {
+		return fmt.Errorf("error storing recovery codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing TOTP enrolment: %w", err)
+	}
+	return nil
+}
+
+// DisableTOTP removes a user's TOTP secret and recovery codes.
+func (s *PostgresStore) DisableTOTP(ctx context.Context, userID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting TOTP removal: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET totp_secret=NULL, totp_enabled=false, totp_last_step=NULL WHERE id=$1`, userID)
+	if err != nil {
+		return fmt.Errorf("error disabling TOTP: %w", err)
+	}
+	if err := expectAffected(res, fmt.Sprintf("user with ID %s not found", userID)); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return fmt.Errorf("error removing recovery codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing TOTP removal: %w", err)
+	}
+	return nil
+}
+
+// ConsumeTOTPStep records that a code for the given time step has been used.
+// It reports false when a code for this or a later step was already accepted, i.e. the code is being replayed.
+func (s *PostgresStore) ConsumeTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	query := `UPDATE users SET totp_last_step=$1
+	WHERE id=$2 AND totp_enabled AND (totp_last_step IS NULL OR totp_last_step < $1)`
+
+	res, err := s.db.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return false, fmt.Errorf("error recording TOTP use: %w", err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return rowsAffected == 1, nil
+}
+
+// ConsumeRecoveryCode marks an unused recovery code of the user as used.
+// It reports false when no such unused code exists.
+func (s *PostgresStore) ConsumeRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	query := `UPDATE recovery_codes SET used_at=now() WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL`
+
+	res, err := s.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("error using recovery code: %w", err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return rowsAffected == 1, nil
+}
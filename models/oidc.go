@@ -0,0 +1,119 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// OIDCLoginState is a single sign-on login in progress, from the redirect to the identity provider
+// until its callback. It binds the callback to the request that started the login.
+type OIDCLoginState struct {
+	StateHash    string    `json:"-" db:"state_hash"`          // SHA-256 hash of the state parameter sent to the identity provider.
+	Nonce        string    `json:"-" db:"nonce"`               // Nonce the ID token must contain.
+	CodeVerifier string    `json:"-" db:"code_verifier"`       // PKCE code verifier sent with the authorization code.
+	ExpiresAt    time.Time `json:"expires_at" db:"expires_at"` // Time after which the login can no longer be completed.
+	CreatedAt    time.Time `json:"created_at" db:"created_at"` // Time the login was started.
+}
+
+// OIDCIdentity is a user as asserted by an OpenID Connect identity provider.
+type OIDCIdentity struct {
+	Issuer        string // Identity provider that authenticated the user.
+	Subject       string // Identifier of the user at the identity provider; stable, unlike the email.
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// CreateOIDCLoginState persists a single sign-on login in progress. Expired logins are pruned on the way.
+func (s *PostgresStore) CreateOIDCLoginState(ctx context.Context, st *OIDCLoginState) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM oidc_login_states WHERE expires_at < now()`); err != nil {
+		return fmt.Errorf("error pruning login states: %w", err)
+	}
+
+	query := `INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, expires_at)
+	VALUES ($1, $2, $3, $4)
+	RETURNING created_at`
+
+	if err := s.db.QueryRowContext(ctx, query, st.StateHash, st.Nonce, st.CodeVerifier, st.ExpiresAt).Scan(&st.CreatedAt); err != nil {
+		return fmt.Errorf("error creating login state: %w", err)
+	}
+	return nil
+}
+
+// ConsumeOIDCLoginState retrieves and deletes a single sign-on login in progress, so that each can be completed once.
+func (s *PostgresStore) ConsumeOIDCLoginState(ctx context.Context, stateHash string) (*OIDCLoginState, error) {
+	query := `DELETE FROM oidc_login_states WHERE state_hash=$1
+	RETURNING state_hash, nonce, code_verifier, expires_at, created_at`
+
+	var st OIDCLoginState
+	err := s.db.QueryRowContext(ctx, query, stateHash).Scan(&st.StateHash, &st.Nonce, &st.CodeVerifier, &st.ExpiresAt, &st.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, newError(ErrInvalidInput, "login state is invalid or has expired")
+		}
+		return nil, fmt.Errorf("error fetching login state: %w", err)
+	}
+	if time.Now().After(st.ExpiresAt) {
+		return nil, newError(ErrInvalidInput, "login state is invalid or has expired")
+	}
+	return &st, nil
+}
+
+// ProvisionOIDCUser returns the account of a user who signed in with single sign-on, creating it on first login.
+//
+// Accounts are matched by issuer and subject. On first login, an existing password account with the same
+// email is linked if the identity provider verified the email; otherwise a new account without a usable
+// password is created. The role is always set from the identity provider's groups, so that changes there
+// take effect at the next login.
+func (s *PostgresStore) ProvisionOIDCUser(ctx context.Context, id *OIDCIdentity, role string) (*User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting single sign-on provisioning: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID string
+	query := `UPDATE users SET name=$3, role=$4 WHERE oidc_issuer=$1 AND oidc_subject=$2 RETURNING id`
+	err = tx.QueryRowContext(ctx, query, id.Issuer, id.Subject, id.Name, role).Scan(&userID)
+	if err == sql.ErrNoRows {
+		userID, err = provisionNewOIDCUser(ctx, tx, id, role)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing single sign-on provisioning: %w", err)
+	}
+	return s.GetUserByID(ctx, userID)
+}
+
+// provisionNewOIDCUser links or creates the account of a user signing in with single sign-on for the first time.
+func provisionNewOIDCUser(ctx context.Context, tx *sql.Tx, id *OIDCIdentity, role string) (string, error) {
+	var userID string
+	if id.EmailVerified {
+		link := `UPDATE users SET role=$2, oidc_issuer=$3, oidc_subject=$4
+		WHERE lower(email)=lower($1) AND oidc_subject IS NULL AND NOT service_account
+		RETURNING id`
+
+		err := tx.QueryRowContext(ctx, link, id.Email, role, id.Issuer, id.Subject).Scan(&userID)
+		if err == nil {
+			return userID, nil
+		}
+		if err != sql.ErrNoRows {
+			return "", userWriteError(err)
+		}
+	}
+
+	insert := `INSERT INTO users (name, email, password, role, oidc_issuer, oidc_subject)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id`
+
+	err := tx.QueryRowContext(ctx, insert, id.Name, id.Email, unusablePassword, role, id.Issuer, id.Subject).Scan(&userID)
+	if err != nil {
+		return "", userWriteError(err)
+	}
+	return userID, nil
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PasswordResetToken allows a user who forgot their password to choose a new one.
+type PasswordResetToken struct {
+	ID        string       `json:"id" db:"id"`                 // Unique identifier for the token.
+	UserID    string       `json:"user_id" db:"user_id"`       // User whose password may be reset.
+	TokenHash string       `json:"-" db:"token_hash"`          // SHA-256 hash of the token sent by e-mail.
+	ExpiresAt time.Time    `json:"expires_at" db:"expires_at"` // Time after which the token can no longer be used.
+	UsedAt    sql.NullTime `json:"used_at" db:"used_at"`       // Set once the token has been used.
+	CreatedAt time.Time    `json:"created_at" db:"created_at"` // Time the token was issued.
+}
+
+// GetUserByEmail retrieves a single user account by its email address (case-insensitive).
+func (s *PostgresStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	query := `SELECT id, name, email, role, disabled, created_at, service_account FROM users WHERE lower(email)=lower($1)`
+
+	var u User
+	err := s.db.QueryRowContext(ctx, query, email).Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.Disabled, &u.CreatedAt, &u.ServiceAccount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, newError(ErrNotFound, "user with email %s not found", email)
+		}
+		return nil, fmt.Errorf("error fetching user by email: %w", err)
+	}
+	return &u, nil
+}
+
+// VerifyPassword reports whether password matches the stored password of the user.
+func (s *PostgresStore) VerifyPassword(ctx context.Context, userID, password string) (bool, error) {
+	var hashed string
+	err := s.db.QueryRowContext(ctx, `SELECT password FROM users WHERE id=$1`, userID).Scan(&hashed)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, newError(ErrNotFound, "user with ID %s not found", userID)
+		}
+		return false, fmt.Errorf("error fetching user password: %w", err)
+	}
+	return checkPassword(hashed, password), nil
+}
+
+// UpdatePassword replaces the password of a user.
+// All sessions and outstanding reset tokens of the user are revoked, signing out other devices.
+func (s *PostgresStore) UpdatePassword(ctx context.Context, userID, password string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting password update: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.setPassword(ctx, tx, userID, password); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing password update: %w", err)
+	}
+	return nil
+}
+
+// CreatePasswordResetToken persists a new reset token. Earlier unused tokens of the user stop working.
+func (s *PostgresStore) CreatePasswordResetToken(ctx context.Context, t *PasswordResetToken) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting password reset: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE password_reset_tokens SET used_at=now() WHERE user_id=$1 AND used_at IS NULL`, t.UserID); err != nil {
+		return fmt.Errorf("error invalidating previous reset tokens: %w", err)
+	}
+
+	query := `INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at`
+	if err := tx.QueryRowContext(ctx, query, t.UserID, t.TokenHash, t.ExpiresAt).Scan(&t.ID, &t.CreatedAt); err != nil {
+		return fmt.Errorf("error creating password reset token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing password reset token: %w", err)
+	}
+	return nil
+}
+
+// GetPasswordResetToken retrieves a reset token that can still be used.
+func (s *PostgresStore) GetPasswordResetToken(ctx context.Context, tokenHash string) (*PasswordResetToken, error) {
+	query := `SELECT id, user_id, token_hash, expires_at, used_at, created_at FROM password_reset_tokens WHERE token_hash=$1`
+
+	var t PasswordResetToken
+	err := s.db.QueryRowContext(ctx, query, tokenHash).Scan(&t.ID, &t.UserID, &t.TokenHash, &t.ExpiresAt, &t.UsedAt, &t.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, newError(ErrInvalidInput, "reset token is invalid or has expired")
+		}
+		return nil, fmt.Errorf("error fetching password reset token: %w", err)
+	}
+	if t.UsedAt.Valid || time.Now().After(t.ExpiresAt) {
+		return nil, newError(ErrInvalidInput, "reset token is invalid or has expired")
+	}
+	return &t, nil
+}
+
+// ResetPassword sets a new password using a single-use reset token and returns the ID of the affected user.
+// Like UpdatePassword, it ends all sessions of the user.
+func (s *PostgresStore) ResetPassword(ctx context.Context, tokenHash, password string) (string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("error starting password reset: %w", err)
+	}
+	defer tx.Rollback()
+
+	var t PasswordResetToken
+	query := `SELECT id, user_id, expires_at, used_at FROM password_reset_tokens WHERE token_hash=$1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, tokenHash).Scan(&t.ID, &t.UserID, &t.ExpiresAt, &t.UsedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", newError(ErrInvalidInput, "reset token is invalid or has expired")
+		}
+		return "", fmt.Errorf("error fetching password reset token: %w", err)
+	}
+	if t.UsedAt.Valid || time.Now().After(t.ExpiresAt) {
+		return "", newError(ErrInvalidInput, "reset token is invalid or has expired")
+	}
+
+	if err := s.setPassword(ctx, tx, t.UserID, password); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing password reset: %w", err)
+	}
+	return t.UserID, nil
+}
+
+// setPassword hashes and stores a new password within tx, ending the user's sessions and revoking unused reset tokens.
+func (s *PostgresStore) setPassword(ctx context.Context, tx *sql.Tx, userID, password string) error {
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET password=$1 WHERE id=$2`, hashedPassword, userID)
+	if err != nil {
+		return fmt.Errorf("error updating password: %w", err)
+	}
+	if err := expectAffected(res, fmt.Sprintf("user with ID %s not found", userID)); err != nil {
+		return err
+	}
+
+	if _, err := revokeUserSessions(ctx, tx, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE password_reset_tokens SET used_at=now() WHERE user_id=$1 AND used_at IS NULL`, userID); err != nil {
+		return fmt.Errorf("error invalidating reset tokens: %w", err)
+	}
+	return nil
+}
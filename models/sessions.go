@@ -0,0 +1,148 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Session is one login of a user on a device. Every access token carries the ID of its session,
+// and the session's refresh tokens form the rotation family with the same ID,
+// so revoking a session signs the device out at once.
+type Session struct {
+	ID         string       `json:"id" db:"id"`                     // Unique identifier; also the family ID of the session's refresh tokens.
+	UserID     string       `json:"user_id" db:"user_id"`           // User who logged in.
+	UserAgent  string       `json:"user_agent" db:"user_agent"`     // User-Agent header of the login request, identifying the device.
+	IP         string       `json:"ip" db:"ip"`                     // Client address the session was last used from.
+	CreatedAt  time.Time    `json:"created_at" db:"created_at"`     // Time of the login.
+	LastSeenAt time.Time    `json:"last_seen_at" db:"last_seen_at"` // Time the session was last used, with a resolution of a minute.
+	ExpiresAt  time.Time    `json:"expires_at" db:"expires_at"`     // Time the session ends unless refreshed; follows its latest refresh token.
+	RevokedAt  sql.NullTime `json:"revoked_at" db:"revoked_at"`     // Set when the user signs out or the session is terminated.
+
+	Current bool `json:"current" db:"-"` // Whether this is the session of the request listing the sessions.
+}
+
+// CreateSession persists a new session.
+func (s *PostgresStore) CreateSession(ctx context.Context, sess *Session) error {
+	query := `INSERT INTO sessions (user_id, user_agent, ip, expires_at)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, last_seen_at`
+
+	err := s.db.QueryRowContext(ctx, query, sess.UserID, sess.UserAgent, sess.IP, sess.ExpiresAt).Scan(&sess.ID, &sess.CreatedAt, &sess.LastSeenAt)
+	if err != nil {
+		return fmt.Errorf("error creating session: %w", err)
+	}
+	return nil
+}
+
+// ListSessions retrieves the active sessions of a user, most recently used first.
+func (s *PostgresStore) ListSessions(ctx context.Context, userID string) ([]*Session, error) {
+	query := `SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at FROM sessions
+	WHERE user_id=$1 AND revoked_at IS NULL AND expires_at > now()
+	ORDER BY last_seen_at DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var sess Session
+		if err := rows.Scan(&sess.ID, &sess.UserID, &sess.UserAgent, &sess.IP, &sess.CreatedAt, &sess.LastSeenAt, &sess.ExpiresAt, &sess.RevokedAt); err != nil {
+			return nil, fmt.Errorf("error scanning session row: %w", err)
+		}
+		sessions = append(sessions, &sess)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// IsSessionActive reports whether the session exists and has neither been revoked nor expired.
+func (s *PostgresStore) IsSessionActive(ctx context.Context, id string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM sessions WHERE id=$1 AND revoked_at IS NULL AND expires_at > now())`
+
+	var active bool
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(&active); err != nil {
+		return false, fmt.Errorf("error checking session status: %w", err)
+	}
+	return active, nil
+}
+
+// TouchSession records that a session was just used from the given address. To avoid a write per request,
+// the session is only updated when it was last seen more than a minute ago or from another address.
+func (s *PostgresStore) TouchSession(ctx context.Context, id, ip string) error {
+	query := `UPDATE sessions SET last_seen_at=now(), ip=$2
+	WHERE id=$1 AND (last_seen_at < now() - interval '1 minute' OR ip <> $2)`
+
+	if _, err := s.db.ExecContext(ctx, query, id, ip); err != nil {
+		return fmt.Errorf("error recording session use: %w", err)
+	}
+	return nil
+}
+
+// RevokeSession signs a user out of one of their sessions, revoking its refresh tokens in the same transaction.
+func (s *PostgresStore) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting session revocation: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE sessions SET revoked_at=now() WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL`, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("error revoking session: %w", err)
+	}
+	if err := expectAffected(res, fmt.Sprintf("session with ID %s not found", sessionID)); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at=now() WHERE family_id=$1 AND revoked_at IS NULL`, sessionID); err != nil {
+		return fmt.Errorf("error revoking refresh tokens of session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing session revocation: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserSessions signs a user out everywhere and returns the number of sessions that were ended.
+func (s *PostgresStore) RevokeUserSessions(ctx context.Context, userID string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting session revocation: %w", err)
+	}
+	defer tx.Rollback()
+
+	n, err := revokeUserSessions(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing session revocation: %w", err)
+	}
+	return n, nil
+}
+
+// revokeUserSessions revokes every session and refresh token of a user within tx.
+func revokeUserSessions(ctx context.Context, tx *sql.Tx, userID string) (int, error) {
+	res, err := tx.ExecContext(ctx, `UPDATE sessions SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL AND expires_at > now()`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("error revoking sessions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL`, userID); err != nil {
+		return 0, fmt.Errorf("error revoking refresh tokens: %w", err)
+	}
+	return int(n), nil
+}
@@ -1,8 +1,13 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -13,86 +18,256 @@ type User struct {
 	Name     string `json:"name" db:"name"`   // Name of the user.
 	Email    string `json:"email" db:"email"` // Email address of the user (used for login).
 	Password string `json:"-" db:"password"`  // Hashed password, excluded from JSON output.
-	Role     string `json:"role" db:"role"`   // Role of the user (e.g., "receptionist", "doctor").
+	Role     string `json:"role" db:"role"`   // Role of the user (e.g., "receptionist", "doctor"); must exist in the roles table.
+
+	Disabled  bool      `json:"disabled" db:"disabled"`     // Disabled accounts can neither log in nor use existing tokens.
+	CreatedAt time.Time `json:"created_at" db:"created_at"` // Time the account was created.
+
+	TOTPEnabled bool `json:"totp_enabled" db:"totp_enabled"` // Whether the user has enrolled a TOTP authenticator.
+	MFARequired bool `json:"mfa_required" db:"-"`            // Whether the user's role requires a second factor, loaded from roles.
+
+	ServiceAccount bool `json:"service_account" db:"service_account"` // Service accounts have no password and authenticate with API keys.
+
+	Permissions []string `json:"permissions,omitempty" db:"-"` // Permissions granted to the user's role, loaded from role_permissions.
 }
 
 // LoginUser represents the data structure for user login requests.
 type LoginUser struct {
-	Email    string `json:"email" db:"email"` // Email provided for login.
-	Password string `json:"-" db:"password"`  // Password provided for login, excluded from JSON output.
+	Email    string `json:"email" db:"email"`       // Email provided for login.
+	Password string `json:"password" db:"password"` // Password provided for login.
 }
 
 // Patient represents a patient record in the system.
 type Patient struct {
-	ID        string         `json:"id" db:"id"`                 // Unique identifier for the patient.
-	Name      string         `json:"name" db:"name"`             // Name of the patient.
-	Age       uint           `json:"age" db:"age"`               // Age of the patient.
-	Gender    string         `json:"gender" db:"gender"`         // Gender of the patient.
-	Diagnosis sql.NullString `json:"diagnosis" db:"diagnosis"`   // Patient's diagnosis, can be null.
-	CreatedBy string         `json:"created_by" db:"created_by"` // User ID of who created/last updated the patient.
+	ID        string         `json:"id" db:"id"`               // Unique identifier for the patient.
+	Name      string         `json:"name" db:"name"`           // Name of the patient.
+	Age       uint           `json:"age" db:"age"`             // Age of the patient.
+	Gender    string         `json:"gender" db:"gender"`       // Gender of the patient.
+	Diagnosis sql.NullString `json:"diagnosis" db:"diagnosis"` // Patient's diagnosis, can be null.
+	Version   int            `json:"version" db:"version"`     // Revision number, incremented by every change; served as the ETag.
+
+	CreatedBy   string         `json:"created_by" db:"created_by"`     // User who registered the patient; never changes.
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`     // Time the patient was registered; never changes.
+	UpdatedBy   string         `json:"updated_by" db:"updated_by"`     // User who last changed the record; set by the store to the editor passed in.
+	UpdatedAt   time.Time      `json:"updated_at" db:"updated_at"`     // Time the record was last changed.
+	DiagnosedBy sql.NullString `json:"diagnosed_by" db:"diagnosed_by"` // User who last set or changed the diagnosis, null when never diagnosed.
+	DiagnosedAt sql.NullTime   `json:"diagnosed_at" db:"diagnosed_at"` // Time the diagnosis was last set or changed.
+
+	DeletedAt      sql.NullTime   `json:"deleted_at" db:"deleted_at"`           // Time the record was deleted; null while it is in use.
+	DeletedBy      sql.NullString `json:"deleted_by" db:"deleted_by"`           // User who deleted the record.
+	DeletionReason sql.NullString `json:"deletion_reason" db:"deletion_reason"` // Why the record was deleted.
+}
+
+// patientColumns lists the patients columns in the order patientFields scans them.
+const patientColumns = `id, name, age, gender, diagnosis, version, created_by, created_at, updated_by, updated_at, diagnosed_by, diagnosed_at,
+	deleted_at, deleted_by, deletion_reason`
+
+// patientFields returns the scan destinations for a row selected with patientColumns.
+func patientFields(p *Patient) []interface{} {
+	return []interface{}{&p.ID, &p.Name, &p.Age, &p.Gender, &p.Diagnosis, &p.Version, &p.CreatedBy, &p.CreatedAt, &p.UpdatedBy, &p.UpdatedAt, &p.DiagnosedBy, &p.DiagnosedAt,
+		&p.DeletedAt, &p.DeletedBy, &p.DeletionReason}
 }
 
 // Storage defines the interface for patient data persistence operations.
+// Methods changing patient records take the audit event describing the change and append it to the audit log
+// in the same transaction, so that no change is committed without its event.
 type Storage interface {
-	AddPatient(*Patient) error
-	GetPatients(nameQuery string, limit, offset int) ([]*Patient, error)
-	GetPatientByID(id string) (*Patient, error)
-	UpdatePatient(*Patient) error
-	DeletePatientByID(id string) error
+	AddPatient(ctx context.Context, p *Patient, audit *AuditEvent) error
+	GetPatients(ctx context.Context, f PatientFilter, limit, offset int) ([]*Patient, error)
+	GetPatientByID(ctx context.Context, id string) (*Patient, error)
+	UpdatePatient(ctx context.Context, p *Patient, audit *AuditEvent) error
+	DeletePatientByID(ctx context.Context, id string, version int, deletedBy, reason string, audit *AuditEvent) error
+	ListDeletedPatients(ctx context.Context, limit, offset int) ([]*Patient, error)
+	UndeletePatient(ctx context.Context, id, editorID string, audit *AuditEvent) (*Patient, error)
+
+	AddCareTeamMember(ctx context.Context, m *CareTeamMember) error
+	ListCareTeam(ctx context.Context, patientID string) ([]*CareTeamMember, error)
+	UpdateCareTeamMember(ctx context.Context, m *CareTeamMember) error
+	RemoveCareTeamMember(ctx context.Context, patientID, memberID string) error
+	IsOnCareTeam(ctx context.Context, userID, patientID string) (bool, error)
+
+	ListPatientVersions(ctx context.Context, patientID string) ([]*PatientVersion, error)
+	GetPatientVersion(ctx context.Context, patientID string, version int) (*PatientVersion, error)
+	RestorePatientVersion(ctx context.Context, patientID string, version, expected int, editorID string, audit *AuditEvent) (*Patient, error)
 }
 
 // Account defines the interface for user account management operations.
 type Account interface {
-	CreateUserAccount(*User) error
-	LoginUserAccount(*LoginUser) (*User, error)
+	CreateUserAccount(ctx context.Context, u *User) error
+	LoginUserAccount(ctx context.Context, u *LoginUser) (*User, error)
+	GetUserByID(ctx context.Context, id string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetRolePermissions(ctx context.Context, role string) ([]string, error)
+
+	ListUsers(ctx context.Context, limit, offset int) ([]*User, error)
+	CreateFirstUserAccount(ctx context.Context, u *User) error
+	UpdateUserRole(ctx context.Context, id, role string) error
+	SetUserDisabled(ctx context.Context, id string, disabled bool) error
+	DeleteUser(ctx context.Context, id string) error
+	CreateInvitation(ctx context.Context, inv *Invitation) error
+	AcceptInvitation(ctx context.Context, tokenHash string, u *User) error
+
+	VerifyPassword(ctx context.Context, userID, password string) (bool, error)
+	UpdatePassword(ctx context.Context, userID, password string) error
+	CreatePasswordResetToken(ctx context.Context, t *PasswordResetToken) error
+	GetPasswordResetToken(ctx context.Context, tokenHash string) (*PasswordResetToken, error)
+	ResetPassword(ctx context.Context, tokenHash, password string) (string, error)
+
+	GetTOTPConfig(ctx context.Context, userID string) (*TOTPConfig, error)
+	SetPendingTOTPSecret(ctx context.Context, userID, secret string) error
+	EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, userID string) error
+	ConsumeTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	ConsumeRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error)
+
+	GetLoginThrottle(ctx context.Context, key string) (*LoginThrottle, error)
+	RecordLoginAttempt(ctx context.Context, key string, window time.Duration) (*LoginThrottle, error)
+	ForgiveLoginAttempt(ctx context.Context, key string) error
+	LockLogin(ctx context.Context, key string, until time.Time, failures int) error
+	ClearLoginFailures(ctx context.Context, key string) error
+	UnlockLogin(ctx context.Context, key, unlockedBy string) (bool, error)
+	ListLoginLockouts(ctx context.Context, activeOnly bool, limit, offset int) ([]*LoginLockout, error)
+
+	CreateRefreshToken(ctx context.Context, t *RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, current, next *RefreshToken) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+
+	CreateSession(ctx context.Context, sess *Session) error
+	ListSessions(ctx context.Context, userID string) ([]*Session, error)
+	IsSessionActive(ctx context.Context, id string) (bool, error)
+	TouchSession(ctx context.Context, id, ip string) error
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+	RevokeUserSessions(ctx context.Context, userID string) (int, error)
+
+	CreateServiceAccount(ctx context.Context, u *User) error
+	CreateAPIKey(ctx context.Context, k *APIKey) error
+	ListAPIKeys(ctx context.Context, serviceAccountID string) ([]*APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	TouchAPIKey(ctx context.Context, id string) error
+	RevokeAPIKey(ctx context.Context, serviceAccountID, keyID string) error
+
+	CreateOIDCLoginState(ctx context.Context, st *OIDCLoginState) error
+	ConsumeOIDCLoginState(ctx context.Context, stateHash string) (*OIDCLoginState, error)
+	ProvisionOIDCUser(ctx context.Context, id *OIDCIdentity, role string) (*User, error)
+
+	CreateEmergencyAccess(ctx context.Context, g *EmergencyAccess, audit *AuditEvent) error
+	GetActiveEmergencyAccess(ctx context.Context, userID, patientID string) (*EmergencyAccess, error)
+	RecordEmergencyAccessUse(ctx context.Context, grantID, action string) error
+	ListEmergencyAccesses(ctx context.Context, f EmergencyAccessFilter, limit, offset int) ([]*EmergencyAccess, error)
+
+	AppendAuditEvent(ctx context.Context, e *AuditEvent) error
+	ListAuditEvents(ctx context.Context, f AuditFilter, limit, offset int) ([]*AuditEvent, error)
+	VerifyAuditLog(ctx context.Context) (*AuditVerification, error)
 }
 
 // PostgresStore implements the Storage interface for PostgreSQL database.
 type PostgresStore struct {
-	db *sql.DB
+	db     *sql.DB
+	hasher PasswordHasher // Hashes new passwords; stored hashes it would not produce are upgraded at login.
+
+	dummyHashOnce sync.Once
+	dummyHash     string
 }
 
 // NewPostgresStore creates a new PostgresStore instance.
-func NewPostgresStore(db *sql.DB) (*PostgresStore, error) {
+// A nil hasher falls back to bcrypt with bcrypt.DefaultCost.
+func NewPostgresStore(db *sql.DB, hasher PasswordHasher) (*PostgresStore, error) {
+	if hasher == nil {
+		hasher = BcryptHasher{Cost: bcrypt.DefaultCost}
+	}
 	return &PostgresStore{
-		db: db,
+		db:     db,
+		hasher: hasher,
 	}, nil
 }
 
-// AddPatient inserts a new patient record into the database.
-func (s *PostgresStore) AddPatient(p *Patient) error {
+// AddPatient inserts a new patient record into the database, together with its first version.
+// p.CreatedBy is the registering user; a diagnosis given at registration is attributed to them too.
+// The audit event is recorded for the new patient's ID.
+func (s *PostgresStore) AddPatient(ctx context.Context, p *Patient, audit *AuditEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := `INSERT INTO patients (
-		name, age, gender, created_by
+		name, age, gender, diagnosis, created_by, updated_by, diagnosed_by, diagnosed_at
 	)
-	VALUES ($1, $2, $3, $4)
-	RETURNING id` // RETURNING id ensures the generated ID is populated back into p.ID
+	VALUES ($1, $2, $3, $4, $5, $5, CASE WHEN $4::text IS NOT NULL THEN $5::uuid END, CASE WHEN $4::text IS NOT NULL THEN now() END)
+	RETURNING ` + patientColumns // Populates the generated ID and timestamps back into p
 
-	err := s.db.QueryRow(query, p.Name, p.Age, p.Gender, p.CreatedBy).Scan(&p.ID)
+	err = tx.QueryRowContext(ctx, query, p.Name, p.Age, p.Gender, p.Diagnosis, p.CreatedBy).Scan(patientFields(p)...)
 	if err != nil {
 		return fmt.Errorf("error inserting patient details: %w", err)
 	}
+
+	if err := insertPatientVersion(ctx, tx, p, p.CreatedBy, PatientChangeCreate, sql.NullInt64{}); err != nil {
+		return err
+	}
+	audit.PatientID = p.ID
+	if err := appendAuditEvent(ctx, tx, audit); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing patient: %w", err)
+	}
 	return nil
 }
 
-// GetPatients retrieves a list of patients from the database.
-// It supports filtering by name (case-insensitive partial match) and pagination.
-func (s *PostgresStore) GetPatients(nameQuery string, limit, offset int) ([]*Patient, error) {
-	query := `SELECT id, name, age, gender, diagnosis, created_by FROM patients`
+// GetPatients retrieves a list of patients from the database, leaving out deleted ones.
+// It supports filtering by name (case-insensitive partial match), by care team member and by who registered,
+// last changed or diagnosed the patient and when, and pagination.
+func (s *PostgresStore) GetPatients(ctx context.Context, f PatientFilter, limit, offset int) ([]*Patient, error) {
+	query := `SELECT ` + patientColumns + ` FROM patients`
 	args := []interface{}{}
-	paramCount := 1
+	conditions := []string{"deleted_at IS NULL"}
+	addCondition := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(cond, len(args)))
+	}
 
-	// Add name filtering if nameQuery is provided
-	if nameQuery != "" {
-		query += fmt.Sprintf(" WHERE name ILIKE '%%' || $%d || '%%'", paramCount)
-		args = append(args, nameQuery)
-		paramCount++
+	// Add name filtering if a name is provided
+	if f.Name != "" {
+		addCondition("name ILIKE '%%' || $%d || '%%'", f.Name)
 	}
+	// Restrict to the patients of the user's current care teams
+	if f.CareTeamMemberID != "" {
+		args = append(args, f.CareTeamMemberID)
+		conditions = append(conditions, fmt.Sprintf("id IN (SELECT patient_id FROM care_team_members WHERE user_id=$%d AND %s)", len(args), activeCareTeamCondition))
+	}
+	if f.CreatedBy != "" {
+		addCondition("created_by=$%d", f.CreatedBy)
+	}
+	if f.UpdatedBy != "" {
+		addCondition("updated_by=$%d", f.UpdatedBy)
+	}
+	if f.DiagnosedBy != "" {
+		addCondition("diagnosed_by=$%d", f.DiagnosedBy)
+	}
+	if !f.CreatedFrom.IsZero() {
+		addCondition("created_at >= $%d", f.CreatedFrom)
+	}
+	if !f.CreatedTo.IsZero() {
+		addCondition("created_at < $%d", f.CreatedTo)
+	}
+	if !f.UpdatedFrom.IsZero() {
+		addCondition("updated_at >= $%d", f.UpdatedFrom)
+	}
+	if !f.UpdatedTo.IsZero() {
+		addCondition("updated_at < $%d", f.UpdatedTo)
+	}
+	query += " WHERE " + strings.Join(conditions, " AND ")
 
 	// Add ordering and pagination
-	query += fmt.Sprintf(" ORDER BY name ASC, id ASC LIMIT $%d OFFSET $%d", paramCount, paramCount+1)
+	query += fmt.Sprintf(" ORDER BY name ASC, id ASC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
 	args = append(args, limit, offset)
 
-	rows, err := s.db.Query(query, args...)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching patients details: %w", err)
 	}
@@ -101,7 +276,7 @@ func (s *PostgresStore) GetPatients(nameQuery string, limit, offset int) ([]*Pat
 	var patients []*Patient
 	for rows.Next() {
 		var p Patient
-		err := rows.Scan(&p.ID, &p.Name, &p.Age, &p.Gender, &p.Diagnosis, &p.CreatedBy)
+		err := rows.Scan(patientFields(&p)...)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning patient row: %w", err)
 		}
@@ -113,110 +288,224 @@ func (s *PostgresStore) GetPatients(nameQuery string, limit, offset int) ([]*Pat
 	return patients, nil
 }
 
-// GetPatientByID retrieves a single patient record by their unique ID.
-func (s *PostgresStore) GetPatientByID(id string) (*Patient, error) {
-	query := `SELECT id, name, age, gender, diagnosis, created_by FROM patients WHERE id=$1`
+// GetPatientByID retrieves a single patient record by their unique ID. Deleted records are not found.
+func (s *PostgresStore) GetPatientByID(ctx context.Context, id string) (*Patient, error) {
+	query := `SELECT ` + patientColumns + ` FROM patients WHERE id=$1 AND deleted_at IS NULL`
 
 	var p Patient
 
-	err := s.db.QueryRow(query, id).Scan(&p.ID, &p.Name, &p.Age, &p.Gender, &p.Diagnosis, &p.CreatedBy)
+	err := s.db.QueryRowContext(ctx, query, id).Scan(patientFields(&p)...)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("patient with ID %s not found", id)
+			return nil, newError(ErrNotFound, "patient with ID %s not found", id)
 		}
 		return nil, fmt.Errorf("error fetching patient details by ID: %w", err)
 	}
 	return &p, nil
 }
 
-// UpdatePatient updates an existing patient record in the database.
-func (s *PostgresStore) UpdatePatient(p *Patient) error {
-	query := `UPDATE patients SET name=$1, age=$2, gender=$3, diagnosis=$4, created_by=$5 WHERE id=$6`
+// UpdatePatient updates an existing patient record in the database and records the result as a new version,
+// edited by p.UpdatedBy. The update only applies while the record is still at p.Version; otherwise it fails
+// with a version conflict. The registration details are left alone; the diagnosis provenance only moves to
+// the editor when the diagnosis actually changes. p is refreshed with the new version and the stored provenance.
+func (s *PostgresStore) UpdatePatient(ctx context.Context, p *Patient, audit *AuditEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	res, err := s.db.Exec(query, p.Name, p.Age, p.Gender, p.Diagnosis, p.CreatedBy, p.ID)
+	// On the right-hand side, diagnosis still refers to the value before the update.
+	query := `UPDATE patients SET name=$1, age=$2, gender=$3, diagnosis=$4, version=version+1, updated_by=$5, updated_at=now(),
+		diagnosed_by = CASE WHEN diagnosis IS DISTINCT FROM $4 THEN $5 ELSE diagnosed_by END,
+		diagnosed_at = CASE WHEN diagnosis IS DISTINCT FROM $4 THEN now() ELSE diagnosed_at END
+	WHERE id=$6 AND version=$7 AND deleted_at IS NULL
+	RETURNING ` + patientColumns
+
+	err = tx.QueryRowContext(ctx, query, p.Name, p.Age, p.Gender, p.Diagnosis, p.UpdatedBy, p.ID, p.Version).Scan(patientFields(p)...)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return patientWriteConflict(ctx, tx, p.ID, "patient with ID %s not found for update")
+		}
 		return fmt.Errorf("error updating patient details: %w", err)
 	}
-	rowsAffected, err := res.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("error getting rows affected during update: %w", err)
+
+	if err := insertPatientVersion(ctx, tx, p, p.UpdatedBy, PatientChangeUpdate, sql.NullInt64{}); err != nil {
+		return err
 	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("patient with ID %s not found for update", p.ID)
+	if err := appendAuditEvent(ctx, tx, audit); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing patient update: %w", err)
 	}
 	return nil
 }
 
-// DeletePatientByID deletes a patient record from the database by their unique ID.
-func (s *PostgresStore) DeletePatientByID(id string) error {
-	query := `DELETE FROM patients WHERE id=$1`
-	res, err := s.db.Exec(query, id)
+// DeletePatientByID marks a patient record as deleted by deletedBy for the given reason and records that as a
+// new version. The record is kept, but hidden from everything except ListDeletedPatients until it is restored
+// with UndeletePatient or purged with PurgeDeletedPatients. The deletion only applies while the record is still
+// at the given version; otherwise it fails with a version conflict.
+func (s *PostgresStore) DeletePatientByID(ctx context.Context, id string, version int, deletedBy, reason string, audit *AuditEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("error deleting patient: %w", err)
+		return fmt.Errorf("error starting transaction: %w", err)
 	}
-	rowsAffected, err := res.RowsAffected()
+	defer tx.Rollback()
+
+	query := `UPDATE patients SET deleted_at=now(), deleted_by=$3, deletion_reason=$4,
+		version=version+1, updated_by=$3, updated_at=now()
+	WHERE id=$1 AND version=$2 AND deleted_at IS NULL
+	RETURNING ` + patientColumns
+
+	var p Patient
+	err = tx.QueryRowContext(ctx, query, id, version, deletedBy, reason).Scan(patientFields(&p)...)
 	if err != nil {
-		return fmt.Errorf("error getting rows affected during deletion: %w", err)
+		if err == sql.ErrNoRows {
+			return patientWriteConflict(ctx, tx, id, "patient with ID %s not found for deletion")
+		}
+		return fmt.Errorf("error deleting patient: %w", err)
 	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("patient with ID %s not found for deletion", id)
+
+	if err := insertPatientVersion(ctx, tx, &p, deletedBy, PatientChangeDelete, sql.NullInt64{}); err != nil {
+		return err
+	}
+	if err := appendAuditEvent(ctx, tx, audit); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing deletion: %w", err)
 	}
 	return nil
 }
 
+// rowQuerier is implemented by *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// patientWriteConflict explains why a conditional write of a patient matched no row: either the patient does
+// not exist (notFound, formatted with the ID) or it is no longer at the expected version.
+func patientWriteConflict(ctx context.Context, q rowQuerier, id, notFound string) error {
+	var current int
+	err := q.QueryRowContext(ctx, `SELECT version FROM patients WHERE id=$1 AND deleted_at IS NULL`, id).Scan(&current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return newError(ErrNotFound, notFound, id)
+		}
+		return fmt.Errorf("error checking patient version: %w", err)
+	}
+	return newError(ErrStaleVersion, "version conflict: patient %s is at version %d", id, current)
+}
+
 // CreateUserAccount inserts a new user account into the database after hashing the password.
-func (s *PostgresStore) CreateUserAccount(u *User) error {
-	hashedPassword, err := hashPassword(u)
+func (s *PostgresStore) CreateUserAccount(ctx context.Context, u *User) error {
+	hashedPassword, err := s.hasher.Hash(u.Password)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %w", err)
 	}
 
 	query := `INSERT INTO users (name, email, password, role)
 	VALUES ($1, $2, $3, $4)
-	RETURNING id`
+	RETURNING id, created_at`
 
-	err = s.db.QueryRow(query, u.Name, u.Email, hashedPassword, u.Role).Scan(&u.ID)
+	err = s.db.QueryRowContext(ctx, query, u.Name, u.Email, hashedPassword, u.Role).Scan(&u.ID, &u.CreatedAt)
 	if err != nil {
-		return fmt.Errorf("error creating user account: %w", err)
+		return userWriteError(err)
 	}
 
 	return nil
 }
 
 // LoginUserAccount authenticates a user by checking their email and password.
-func (s *PostgresStore) LoginUserAccount(u *LoginUser) (*User, error) {
+func (s *PostgresStore) LoginUserAccount(ctx context.Context, u *LoginUser) (*User, error) {
 	var dbuser User
 
-	query := `SELECT id, name, email, password, role FROM users WHERE email=$1`
+	query := `SELECT u.id, u.name, u.email, u.password, u.role, u.disabled, u.created_at, u.totp_enabled, r.mfa_required
+	FROM users u JOIN roles r ON r.name = u.role WHERE u.email=$1 AND NOT u.service_account`
 
-	err := s.db.QueryRow(query, u.Email).Scan(&dbuser.ID, &dbuser.Name, &dbuser.Email, &dbuser.Password, &dbuser.Role)
+	err := s.db.QueryRowContext(ctx, query, u.Email).Scan(&dbuser.ID, &dbuser.Name, &dbuser.Email, &dbuser.Password, &dbuser.Role, &dbuser.Disabled, &dbuser.CreatedAt, &dbuser.TOTPEnabled, &dbuser.MFARequired)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("invalid email or password") // Generic error for security
+			// Spend as long as a real password check so response times do not reveal which emails exist.
+			checkPassword(s.dummyPasswordHash(), u.Password)
+			return nil, newError(ErrInvalidCredentials, "invalid email or password") // Generic error for security
 		}
 		return nil, fmt.Errorf("error retrieving user for login: %w", err)
 	}
 
 	// Compare the provided password with the hashed password from the database
 	if !checkPassword(dbuser.Password, u.Password) {
-		return nil, fmt.Errorf("invalid email or password") // Generic error for security
+		return nil, newError(ErrInvalidCredentials, "invalid email or password") // Generic error for security
+	}
+
+	if dbuser.Disabled {
+		return nil, newError(ErrAccountDisabled, "account is disabled")
+	}
+
+	// The plaintext password is only available now, so this is the one chance to upgrade an outdated hash.
+	if s.hasher.NeedsRehash(dbuser.Password) {
+		s.rehashPassword(ctx, dbuser.ID, dbuser.Password, u.Password)
+	}
+
+	if dbuser.Permissions, err = s.GetRolePermissions(ctx, dbuser.Role); err != nil {
+		return nil, err
 	}
 
 	return &dbuser, nil
 }
 
-// hashPassword generates a bcrypt hash of the user's password.
-func hashPassword(u *User) ([]byte, error) {
-	password, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+// GetRolePermissions returns the names of the permissions granted to a role.
+func (s *PostgresStore) GetRolePermissions(ctx context.Context, role string) ([]string, error) {
+	query := `SELECT permission FROM role_permissions WHERE role=$1 ORDER BY permission`
+
+	rows, err := s.db.QueryContext(ctx, query, role)
 	if err != nil {
-		return nil, fmt.Errorf("bcrypt hashing failed: %w", err)
+		return nil, fmt.Errorf("error fetching role permissions: %w", err)
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, fmt.Errorf("error scanning role permission row: %w", err)
+		}
+		permissions = append(permissions, p)
 	}
-	return password, nil
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning role permissions: %w", err)
+	}
+	return permissions, nil
 }
 
-// checkPassword compares a plaintext password with a bcrypt hashed password.
-func checkPassword(hashedPassword, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+// rehashPassword replaces a stored password hash with one from the configured hasher.
+// It only logs failures: the login has already succeeded and the upgrade is retried on the next one.
+// The update is skipped when the password was changed concurrently.
+func (s *PostgresStore) rehashPassword(ctx context.Context, userID, oldHash, password string) {
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %s: %v", userID, err)
+		return
+	}
+
+	query := `UPDATE users SET password=$1 WHERE id=$2 AND password=$3`
+	if _, err := s.db.ExecContext(ctx, query, hashedPassword, userID, oldHash); err != nil {
+		log.Printf("Failed to store rehashed password of user %s: %v", userID, err)
+	}
+}
+
+// dummyPasswordHash returns a hash of a random password, compared against when no account matches a login.
+// It is created by the configured hasher so the comparison takes as long as a real one.
+func (s *PostgresStore) dummyPasswordHash() string {
+	s.dummyHashOnce.Do(func() {
+		hashed, err := s.hasher.Hash(time.Now().String())
+		if err == nil {
+			s.dummyHash = hashed
+		}
+	})
+	return s.dummyHash
 }
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// LoginThrottle tracks recent failed login attempts for one throttle key, such as an account or a client IP.
+type LoginThrottle struct {
+	Key           string       `json:"key" db:"key"`                         // What is being throttled, e.g. "account:alice@example.com" or "ip:10.0.0.1".
+	Failures      int          `json:"failures" db:"failures"`               // Failed or still unsettled attempts within the failure window.
+	LastFailureAt time.Time    `json:"last_failure_at" db:"last_failure_at"` // Time of the most recent attempt counted.
+	LockedUntil   sql.NullTime `json:"locked_until" db:"locked_until"`       // Set while the key is locked out.
+}
+
+// LoginLockout is an audit record of a key being locked out after too many failed attempts.
+type LoginLockout struct {
+	ID          string         `json:"id" db:"id"`                     // Unique identifier for the lockout.
+	Key         string         `json:"key" db:"key"`                   // Throttle key that was locked.
+	Failures    int            `json:"failures" db:"failures"`         // Number of failures that triggered the lockout.
+	LockedUntil time.Time      `json:"locked_until" db:"locked_until"` // Time the lockout ends on its own.
+	UnlockedAt  sql.NullTime   `json:"unlocked_at" db:"unlocked_at"`   // Set when an administrator lifted the lockout early.
+	UnlockedBy  sql.NullString `json:"unlocked_by" db:"unlocked_by"`   // Administrator who lifted the lockout.
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`     // Time the lockout started.
+}
+
+// GetLoginThrottle returns the throttle state of a key. Keys without recorded failures yield a zero state.
+func (s *PostgresStore) GetLoginThrottle(ctx context.Context, key string) (*LoginThrottle, error) {
+	query := `SELECT key, failures, last_failure_at, locked_until FROM login_throttles WHERE key=$1`
+
+	t := LoginThrottle{Key: key}
+	err := s.db.QueryRowContext(ctx, query, key).Scan(&t.Key, &t.Failures, &t.LastFailureAt, &t.LockedUntil)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error fetching login throttle: %w", err)
+	}
+	return &t, nil
+}
+
+// RecordLoginAttempt counts an attempt for a key before its credentials are checked and returns the new state.
+// Counting and reading the count is one statement, so parallel attempts each see a different count.
+// Failures older than window are forgotten, so the count restarts at one after a quiet period.
+// Stale entries of other keys are pruned as a side effect.
+func (s *PostgresStore) RecordLoginAttempt(ctx context.Context, key string, window time.Duration) (*LoginThrottle, error) {
+	query := `INSERT INTO login_throttles (key, failures, last_failure_at) VALUES ($1, 1, now())
+	ON CONFLICT (key) DO UPDATE SET
+		failures = CASE WHEN login_throttles.last_failure_at < now() - make_interval(secs => $2)
+			THEN 1 ELSE login_throttles.failures + 1 END,
+		last_failure_at = now()
+	RETURNING key, failures, last_failure_at, locked_until`
+
+	var t LoginThrottle
+	err := s.db.QueryRowContext(ctx, query, key, window.Seconds()).Scan(&t.Key, &t.Failures, &t.LastFailureAt, &t.LockedUntil)
+	if err != nil {
+		return nil, fmt.Errorf("error recording login attempt: %w", err)
+	}
+
+	prune := `DELETE FROM login_throttles
+	WHERE last_failure_at < now() - make_interval(secs => $1) AND (locked_until IS NULL OR locked_until < now())`
+	if _, err := s.db.ExecContext(ctx, prune, window.Seconds()); err != nil {
+		return nil, fmt.Errorf("error pruning login throttles: %w", err)
+	}
+	return &t, nil
+}
+
+// LockLogin locks a key until the given time and records the lockout in the audit trail.
+func (s *PostgresStore) LockLogin(ctx context.Context, key string, until time.Time, failures int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting login lockout: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE login_throttles SET locked_until=$1 WHERE key=$2`, until, key); err != nil {
+		return fmt.Errorf("error locking login: %w", err)
+	}
+
+	insert := `INSERT INTO login_lockouts (key, failures, locked_until) VALUES ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, insert, key, failures, until); err != nil {
+		return fmt.Errorf("error recording login lockout: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing login lockout: %w", err)
+	}
+	return nil
+}
+
+// ForgiveLoginAttempt takes back one attempt counted by RecordLoginAttempt, e.g. after it succeeded.
+func (s *PostgresStore) ForgiveLoginAttempt(ctx context.Context, key string) error {
+	if _, err := s.db.ExecContext(ctx, `UPDATE login_throttles SET failures = failures - 1 WHERE key=$1 AND failures > 0`, key); err != nil {
+		return fmt.Errorf("error forgiving login attempt: %w", err)
+	}
+	return nil
+}
+
+// ClearLoginFailures forgets the failed attempts of a key, e.g. after a successful login.
+func (s *PostgresStore) ClearLoginFailures(ctx context.Context, key string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM login_throttles WHERE key=$1 AND (locked_until IS NULL OR locked_until < now())`, key); err != nil {
+		return fmt.Errorf("error clearing login failures: %w", err)
+	}
+	return nil
+}
+
+// UnlockLogin lifts an active lockout of a key before it ends and records the administrator who did so.
+// It reports false when the key was not locked.
+func (s *PostgresStore) UnlockLogin(ctx context.Context, key, unlockedBy string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting login unlock: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM login_throttles WHERE key=$1`, key)
+	if err != nil {
+		return false, fmt.Errorf("error unlocking login: %w", err)
+	}
+	cleared, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	update := `UPDATE login_lockouts SET unlocked_at=now(), unlocked_by=$1
+	WHERE key=$2 AND unlocked_at IS NULL AND locked_until > now()`
+	res, err = tx.ExecContext(ctx, update, unlockedBy, key)
+	if err != nil {
+		return false, fmt.Errorf("error recording login unlock: %w", err)
+	}
+	unlocked, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing login unlock: %w", err)
+	}
+	return cleared > 0 || unlocked > 0, nil
+}
+
+// ListLoginLockouts retrieves a page of lockout records, newest first.
+// When activeOnly is set, only lockouts that are still in effect are returned.
+func (s *PostgresStore) ListLoginLockouts(ctx context.Context, activeOnly bool, limit, offset int) ([]*LoginLockout, error) {
+	query := `SELECT id, key, failures, locked_until, unlocked_at, unlocked_by, created_at FROM login_lockouts`
+	if activeOnly {
+		query += ` WHERE unlocked_at IS NULL AND locked_until > now()`
+	}
+	query += ` ORDER BY created_at DESC, id ASC LIMIT $1 OFFSET $2`
+
+	rows, err := s.db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching login lockouts: %w", err)
+	}
+	defer rows.Close()
+
+	lockouts := []*LoginLockout{}
+	for rows.Next() {
+		var l LoginLockout
+		if err := rows.Scan(&l.ID, &l.Key, &l.Failures, &l.LockedUntil, &l.UnlockedAt, &l.UnlockedBy, &l.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning login lockout row: %w", err)
+		}
+		lockouts = append(lockouts, &l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning rows: %w", err)
+	}
+	return lockouts, nil
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RefreshToken represents a persisted refresh token.
+// Tokens issued from the same login share a FamilyID; every use rotates the token
+// and marks the previous one as replaced, so replaying an old token is detectable.
+type RefreshToken struct {
+	ID         string         `json:"id" db:"id"`                   // Unique identifier for the token.
+	UserID     string         `json:"user_id" db:"user_id"`         // User the token was issued to.
+	FamilyID   string         `json:"family_id" db:"family_id"`     // Rotation family shared by all tokens of one login; the ID of its session.
+	TokenHash  string         `json:"-" db:"token_hash"`            // SHA-256 hash of the opaque token, never the token itself.
+	ExpiresAt  time.Time      `json:"expires_at" db:"expires_at"`   // Time after which the token can no longer be used.
+	RevokedAt  sql.NullTime   `json:"revoked_at" db:"revoked_at"`   // Set once the token is rotated or its family is revoked.
+	ReplacedBy sql.NullString `json:"replaced_by" db:"replaced_by"` // ID of the token issued when this one was rotated.
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`   // Time the token was issued.
+
+	AuthMethods []string `json:"auth_methods" db:"auth_methods"` // How the user authenticated at login (e.g. "pwd", "otp"); carried across rotations.
+}
+
+// CreateRefreshToken persists a new refresh token.
+// The family ID is normally the ID of the session the token belongs to; when t.FamilyID is empty a new rotation family is started.
+func (s *PostgresStore) CreateRefreshToken(ctx context.Context, t *RefreshToken) error {
+	query := `INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, auth_methods)
+	VALUES ($1, COALESCE(NULLIF($2, '')::uuid, gen_random_uuid()), $3, $4, $5)
+	RETURNING id, family_id, created_at`
+
+	err := s.db.QueryRowContext(ctx, query, t.UserID, t.FamilyID, t.TokenHash, t.ExpiresAt, pq.Array(t.AuthMethods)).Scan(&t.ID, &t.FamilyID, &t.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error creating refresh token: %w", err)
+	}
+	return nil
+}
+
+// GetRefreshToken retrieves a refresh token by the hash of its opaque value.
+func (s *PostgresStore) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	query := `SELECT id, user_id, family_id, token_hash, expires_at, revoked_at, replaced_by, created_at, auth_methods
+	FROM refresh_tokens WHERE token_hash=$1`
+
+	var t RefreshToken
+	err := s.db.QueryRowContext(ctx, query, tokenHash).Scan(&t.ID, &t.UserID, &t.FamilyID, &t.TokenHash, &t.ExpiresAt, &t.RevokedAt, &t.ReplacedBy, &t.CreatedAt, pq.Array(&t.AuthMethods))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, newError(ErrNotFound, "refresh token not found")
+		}
+		return nil, fmt.Errorf("error fetching refresh token: %w", err)
+	}
+	return &t, nil
+}
+
+// RotateRefreshToken atomically revokes the current token and persists its replacement in the same family,
+// carrying over the authentication methods of the original login.
+// It fails if the current token was already revoked, which indicates a replayed token.
+func (s *PostgresStore) RotateRefreshToken(ctx context.Context, current, next *RefreshToken) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting refresh token rotation: %w", err)
+	}
+	defer tx.Rollback()
+
+	next.UserID = current.UserID
+	next.FamilyID = current.FamilyID
+	next.AuthMethods = current.AuthMethods
+
+	insert := `INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, auth_methods)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, insert, next.UserID, next.FamilyID, next.TokenHash, next.ExpiresAt, pq.Array(next.AuthMethods)).Scan(&next.ID, &next.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error creating rotated refresh token: %w", err)
+	}
+
+	update := `UPDATE refresh_tokens SET revoked_at=now(), replaced_by=$1 WHERE id=$2 AND revoked_at IS NULL`
+	res, err := tx.ExecContext(ctx, update, next.ID, current.ID)
+	if err != nil {
+		return fmt.Errorf("error revoking rotated refresh token: %w", err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected during rotation: %w", err)
+	}
+	if rowsAffected == 0 {
+		return newError(ErrConflict, "refresh token %s has already been used", current.ID)
+	}
+
+	// The session lasts as long as its latest refresh token.
+	if _, err := tx.ExecContext(ctx, `UPDATE sessions SET expires_at=$1 WHERE id=$2`, next.ExpiresAt, next.FamilyID); err != nil {
+		return fmt.Errorf("error extending session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing refresh token rotation: %w", err)
+	}
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every still-active refresh token in the given rotation family
+// and ends the session it belongs to, so that its access tokens stop working too.
+func (s *PostgresStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting refresh token family revocation: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE refresh_tokens SET revoked_at=now() WHERE family_id=$1 AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, familyID); err != nil {
+		return fmt.Errorf("error revoking refresh token family: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE sessions SET revoked_at=now() WHERE id=$1 AND revoked_at IS NULL`, familyID); err != nil {
+		return fmt.Errorf("error revoking session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing refresh token family revocation: %w", err)
+	}
+	return nil
+}
+
+// RevokeAccessToken adds an access token ID to the revocation list until the token would have expired anyway.
+// Entries for tokens that have already expired are pruned as a side effect.
+func (s *PostgresStore) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	query := `INSERT INTO revoked_access_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`
+	if _, err := s.db.ExecContext(ctx, query, jti, expiresAt); err != nil {
+		return fmt.Errorf("error revoking access token: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM revoked_access_tokens WHERE expires_at < now()`); err != nil {
+		return fmt.Errorf("error pruning revoked access tokens: %w", err)
+	}
+	return nil
+}
+
+// IsAccessTokenRevoked reports whether the access token with the given ID is on the revocation list.
+func (s *PostgresStore) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti=$1)`
+
+	var revoked bool
+	if err := s.db.QueryRowContext(ctx, query, jti).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("error checking access token revocation: %w", err)
+	}
+	return revoked, nil
+}
@@ -0,0 +1,255 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// firstUserLockID is the advisory lock serialising the creation of the first user account.
+const firstUserLockID = 7300115
+
+// Invitation allows someone to self-register with a role chosen by an administrator.
+type Invitation struct {
+	ID        string       `json:"id" db:"id"`                 // Unique identifier for the invitation.
+	Email     string       `json:"email" db:"email"`           // Email the invitation is restricted to; empty means anyone holding the token.
+	Role      string       `json:"role" db:"role"`             // Role the new account will receive.
+	TokenHash string       `json:"-" db:"token_hash"`          // SHA-256 hash of the invitation token.
+	InvitedBy string       `json:"invited_by" db:"invited_by"` // Administrator who created the invitation.
+	ExpiresAt time.Time    `json:"expires_at" db:"expires_at"` // Time after which the invitation can no longer be used.
+	UsedAt    sql.NullTime `json:"used_at" db:"used_at"`       // Set once the invitation has been accepted.
+	CreatedAt time.Time    `json:"created_at" db:"created_at"` // Time the invitation was created.
+}
+
+// GetUserByID retrieves a single user account, including its role's permissions, by its unique ID.
+func (s *PostgresStore) GetUserByID(ctx context.Context, id string) (*User, error) {
+	query := `SELECT u.id, u.name, u.email, u.password, u.role, u.disabled, u.created_at, u.totp_enabled, r.mfa_required, u.service_account
+	FROM users u JOIN roles r ON r.name = u.role WHERE u.id=$1`
+
+	var u User
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&u.ID, &u.Name, &u.Email, &u.Password, &u.Role, &u.Disabled, &u.CreatedAt, &u.TOTPEnabled, &u.MFARequired, &u.ServiceAccount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, newError(ErrNotFound, "user with ID %s not found", id)
+		}
+		return nil, fmt.Errorf("error fetching user by ID: %w", err)
+	}
+
+	if u.Permissions, err = s.GetRolePermissions(ctx, u.Role); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ListUsers retrieves a page of user accounts ordered by name.
+func (s *PostgresStore) ListUsers(ctx context.Context, limit, offset int) ([]*User, error) {
+	query := `SELECT id, name, email, role, disabled, created_at, totp_enabled, service_account FROM users
+	ORDER BY name ASC, id ASC LIMIT $1 OFFSET $2`
+
+	rows, err := s.db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.Disabled, &u.CreatedAt, &u.TOTPEnabled, &u.ServiceAccount); err != nil {
+			return nil, fmt.Errorf("error scanning user row: %w", err)
+		}
+		users = append(users, &u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning rows: %w", err)
+	}
+	return users, nil
+}
+
+// CreateFirstUserAccount creates the user account described by u only if no account exists yet, and returns a
+// conflict error otherwise. The check and the insert are atomic, so that concurrent registrations on an empty
+// database cannot both succeed.
+func (s *PostgresStore) CreateFirstUserAccount(ctx context.Context, u *User) error {
+	hashedPassword, err := s.hasher.Hash(u.Password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting user creation: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Under READ COMMITTED a concurrent insert is invisible until it commits, so NOT EXISTS alone is not enough.
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, firstUserLockID); err != nil {
+		return fmt.Errorf("error locking user creation: %w", err)
+	}
+
+	query := `INSERT INTO users (name, email, password, role)
+	SELECT $1, $2, $3, $4 WHERE NOT EXISTS (SELECT 1 FROM users)
+	RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, u.Name, u.Email, hashedPassword, u.Role).Scan(&u.ID, &u.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return newError(ErrConflict, "user accounts already exist")
+		}
+		return userWriteError(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing user creation: %w", err)
+	}
+	return nil
+}
+
+// UpdateUserRole changes the role of a user account.
+func (s *PostgresStore) UpdateUserRole(ctx context.Context, id, role string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET role=$1 WHERE id=$2`, role, id)
+	if err != nil {
+		return userWriteError(err)
+	}
+	return expectAffected(res, fmt.Sprintf("user with ID %s not found", id))
+}
+
+// SetUserDisabled enables or disables a user account.
+// Disabling an account also ends all of its sessions so that no new access tokens can be obtained.
+func (s *PostgresStore) SetUserDisabled(ctx context.Context, id string, disabled bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting user update: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET disabled=$1 WHERE id=$2`, disabled, id)
+	if err != nil {
+		return fmt.Errorf("error updating user status: %w", err)
+	}
+	if err := expectAffected(res, fmt.Sprintf("user with ID %s not found", id)); err != nil {
+		return err
+	}
+
+	if disabled {
+		if _, err := revokeUserSessions(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing user update: %w", err)
+	}
+	return nil
+}
+
+// DeleteUser permanently deletes a user account.
+// Accounts referenced by patient records, their revision history or emergency access grants cannot be deleted and
+// should be disabled instead.
+func (s *PostgresStore) DeleteUser(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id=$1`, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pqForeignKeyViolation {
+			return &Error{Kind: ErrConstraint, Message: fmt.Sprintf("user with ID %s is referenced by patient records, patient record versions or emergency access grants and cannot be deleted; disable the account instead", id), Err: err}
+		}
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+	return expectAffected(res, fmt.Sprintf("user with ID %s not found", id))
+}
+
+// CreateInvitation persists a new registration invitation.
+func (s *PostgresStore) CreateInvitation(ctx context.Context, inv *Invitation) error {
+	query := `INSERT INTO invitations (email, role, token_hash, invited_by, expires_at)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	err := s.db.QueryRowContext(ctx, query, inv.Email, inv.Role, inv.TokenHash, inv.InvitedBy, inv.ExpiresAt).Scan(&inv.ID, &inv.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pqForeignKeyViolation && pqErr.Constraint == "invitations_role_fkey" {
+			return &Error{Kind: ErrInvalidInput, Message: fmt.Sprintf("role %q does not exist", inv.Role), Err: err}
+		}
+		return fmt.Errorf("error creating invitation: %w", err)
+	}
+	return nil
+}
+
+// AcceptInvitation creates the user account described by u using a single-use invitation.
+// The account receives the invitation's role, and the invitation is marked as used in the same transaction.
+func (s *PostgresStore) AcceptInvitation(ctx context.Context, tokenHash string, u *User) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting invitation acceptance: %w", err)
+	}
+	defer tx.Rollback()
+
+	var inv Invitation
+	query := `SELECT id, email, role, expires_at, used_at FROM invitations WHERE token_hash=$1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, tokenHash).Scan(&inv.ID, &inv.Email, &inv.Role, &inv.ExpiresAt, &inv.UsedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return newError(ErrNotFound, "invitation not found")
+		}
+		return fmt.Errorf("error fetching invitation: %w", err)
+	}
+
+	if inv.UsedAt.Valid || time.Now().After(inv.ExpiresAt) {
+		return newError(ErrInvalidInput, "invitation is invalid or has expired")
+	}
+	if inv.Email != "" && !strings.EqualFold(inv.Email, u.Email) {
+		return newError(ErrInvalidInput, "invitation is invalid or has expired")
+	}
+
+	hashedPassword, err := s.hasher.Hash(u.Password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	u.Role = inv.Role
+	insert := `INSERT INTO users (name, email, password, role)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`
+	if err := tx.QueryRowContext(ctx, insert, u.Name, u.Email, hashedPassword, u.Role).Scan(&u.ID, &u.CreatedAt); err != nil {
+		return userWriteError(err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE invitations SET used_at=now() WHERE id=$1`, inv.ID); err != nil {
+		return fmt.Errorf("error marking invitation as used: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing invitation acceptance: %w", err)
+	}
+	return nil
+}
+
+// userWriteError translates constraint violations raised while writing a user row into readable errors.
+func userWriteError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code == pqUniqueViolation && pqErr.Constraint == "users_email_key":
+			return &Error{Kind: ErrDuplicateEmail, Message: "an account with this email already exists", Err: err}
+		case pqErr.Code == pqForeignKeyViolation && pqErr.Constraint == "users_role_fkey":
+			return &Error{Kind: ErrInvalidInput, Message: "role does not exist", Err: err}
+		}
+	}
+	return fmt.Errorf("error writing user account: %w", err)
+}
+
+// expectAffected returns a not found error with the given message when res reports that no rows were changed.
+func expectAffected(res sql.Result, notFound string) error {
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return newError(ErrNotFound, "%s", notFound)
+	}
+	return nil
+}
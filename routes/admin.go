@@ -0,0 +1,251 @@
+package routes
+
+import (
+	"time"
+
+	auth "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/auth"
+	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// adminRole is the role given to the bootstrap account created by the first registration.
+	adminRole = "admin"
+
+	// defaultInvitationTTL is how long an invitation stays valid when no expiry is requested.
+	defaultInvitationTTL = 72 * time.Hour
+)
+
+// handleListUsers retrieves a page of user accounts.
+func (s *APIServer) handleListUsers(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)    // Default to page 1
+	limit := c.QueryInt("limit", 20) // Default to 20 items per page
+
+	if page <= 0 {
+		page = 1
+	}
+	if limit <= 0 {
+		limit = 20
+	}
+
+	users, err := s.account.ListUsers(c.UserContext(), limit, (page-1)*limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(users)
+}
+
+// handleGetUser retrieves a single user account by its ID.
+func (s *APIServer) handleGetUser(c *fiber.Ctx) error {
+	user, err := s.account.GetUserByID(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(user)
+}
+
+// handleAdminCreateUser lets an administrator create an account with any existing role.
+func (s *APIServer) handleAdminCreateUser(c *fiber.Ctx) error {
+	var reqBody struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.Name == "" || reqBody.Email == "" || reqBody.Password == "" || reqBody.Role == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Name, email, password and role are required."})
+	}
+	if err := s.passwords.Validate(reqBody.Password, reqBody.Email); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	u := models.User{
+		Name:     reqBody.Name,
+		Email:    reqBody.Email,
+		Password: reqBody.Password,
+		Role:     reqBody.Role,
+	}
+
+	if err := s.account.CreateUserAccount(c.UserContext(), &u); err != nil {
+		return err
+	}
+
+	u.Password = "" // Clear password before sending response for security
+	return c.Status(fiber.StatusCreated).JSON(u)
+}
+
+// handleUpdateUser changes the role and/or the enabled state of a user account.
+// Administrators cannot change their own account, so they cannot lock themselves out.
+func (s *APIServer) handleUpdateUser(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var reqBody struct {
+		Role     *string `json:"role"`
+		Disabled *bool   `json:"disabled"`
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.Role == nil && reqBody.Disabled == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Nothing to update. Provide role and/or disabled."})
+	}
+	if c.Locals("userID") == id {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Administrators cannot change their own account."})
+	}
+
+	if reqBody.Role != nil {
+		if *reqBody.Role == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Role cannot be empty."})
+		}
+		if err := s.account.UpdateUserRole(c.UserContext(), id, *reqBody.Role); err != nil {
+			return err
+		}
+	}
+	if reqBody.Disabled != nil {
+		if err := s.account.SetUserDisabled(c.UserContext(), id, *reqBody.Disabled); err != nil {
+			return err
+		}
+	}
+
+	return s.handleGetUser(c)
+}
+
+// handleDeleteUser permanently deletes a user account.
+func (s *APIServer) handleDeleteUser(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	if c.Locals("userID") == id {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Administrators cannot delete their own account."})
+	}
+
+	if err := s.account.DeleteUser(c.UserContext(), id); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleResetUserMFA removes a user's TOTP enrolment and recovery codes, e.g. after they lost their device.
+// The user has to enrol again on their next login.
+func (s *APIServer) handleResetUserMFA(c *fiber.Ctx) error {
+	if err := s.account.DisableTOTP(c.UserContext(), c.Params("id")); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleUnlockUser lifts the login and second-factor lockouts of a user account before they end on their own.
+func (s *APIServer) handleUnlockUser(c *fiber.Ctx) error {
+	user, err := s.account.GetUserByID(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return s.unlockLogin(c, auth.AccountThrottleKey(user.Email), auth.MFAThrottleKey(user.ID))
+}
+
+// handleUnlockIP lifts a login lockout of a client IP address before it ends on its own.
+func (s *APIServer) handleUnlockIP(c *fiber.Ctx) error {
+	return s.unlockLogin(c, auth.IPThrottleKey(c.Params("ip")))
+}
+
+// unlockLogin clears the failed attempts of the throttle keys and records the administrator lifting their lockouts.
+func (s *APIServer) unlockLogin(c *fiber.Ctx, keys ...string) error {
+	adminID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	anyUnlocked := false
+	for _, key := range keys {
+		unlocked, err := s.account.UnlockLogin(c.UserContext(), key, adminID)
+		if err != nil {
+			return err
+		}
+		anyUnlocked = anyUnlocked || unlocked
+	}
+	if !anyUnlocked {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "No failed login attempts recorded"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Login unlocked"})
+}
+
+// handleListLockouts retrieves a page of login lockouts, newest first; ?active=true limits it to lockouts still in effect.
+func (s *APIServer) handleListLockouts(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)    // Default to page 1
+	limit := c.QueryInt("limit", 20) // Default to 20 items per page
+
+	if page <= 0 {
+		page = 1
+	}
+	if limit <= 0 {
+		limit = 20
+	}
+
+	lockouts, err := s.account.ListLoginLockouts(c.UserContext(), c.QueryBool("active"), limit, (page-1)*limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(lockouts)
+}
+
+// handleCreateInvitation creates a single-use registration invitation for a role.
+// The invitation token is only returned in this response; only its hash is stored.
+func (s *APIServer) handleCreateInvitation(c *fiber.Ctx) error {
+	var reqBody struct {
+		Email          string `json:"email"`
+		Role           string `json:"role"`
+		ExpiresInHours int    `json:"expires_in_hours"`
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.Role == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Role is required."})
+	}
+	if reqBody.ExpiresInHours < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "expires_in_hours must be a positive number."})
+	}
+
+	ttl := defaultInvitationTTL
+	if reqBody.ExpiresInHours > 0 {
+		ttl = time.Duration(reqBody.ExpiresInHours) * time.Hour
+	}
+
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	inv := &models.Invitation{
+		Email:     reqBody.Email,
+		Role:      reqBody.Role,
+		TokenHash: tokenHash,
+		InvitedBy: userID,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.account.CreateInvitation(c.UserContext(), inv); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"invitation":   inv,
+		"invite_token": token,
+	})
+}
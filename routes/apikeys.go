@@ -0,0 +1,139 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	auth "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/auth"
+	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// handleCreateServiceAccount creates a service account for an integration, e.g. a lab or billing system.
+// Service accounts have a role like users but no password; they authenticate with API keys.
+func (s *APIServer) handleCreateServiceAccount(c *fiber.Ctx) error {
+	var reqBody struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.Name == "" || reqBody.Role == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Name and role are required."})
+	}
+
+	u := models.User{
+		Name: reqBody.Name,
+		Role: reqBody.Role,
+	}
+
+	if err := s.account.CreateServiceAccount(c.UserContext(), &u); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(u)
+}
+
+// handleListAPIKeys retrieves the API keys of a service account. Key values are never returned.
+func (s *APIServer) handleListAPIKeys(c *fiber.Ctx) error {
+	if _, err := s.serviceAccount(c.UserContext(), c.Params("id")); err != nil {
+		return err
+	}
+
+	keys, err := s.account.ListAPIKeys(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(keys)
+}
+
+// handleCreateAPIKey issues a new API key for a service account.
+// The key is only returned in this response; only its hash is stored.
+func (s *APIServer) handleCreateAPIKey(c *fiber.Ctx) error {
+	var reqBody struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.Name == "" || len(reqBody.Scopes) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Name and at least one scope are required."})
+	}
+	if reqBody.ExpiresInDays < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "expires_in_days must be a positive number."})
+	}
+
+	sa, err := s.serviceAccount(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	// A key can only be scoped to permissions its service account's role already has.
+	granted := auth.APIKeyPermissions(reqBody.Scopes, sa.Permissions)
+	if len(granted) != len(reqBody.Scopes) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Scopes must be permissions of the service account's role %q: %s", sa.Role, strings.Join(sa.Permissions, ", ")),
+		})
+	}
+
+	adminID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	key, prefix, keyHash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return err
+	}
+
+	apiKey := &models.APIKey{
+		ServiceAccountID: sa.ID,
+		Name:             reqBody.Name,
+		Prefix:           prefix,
+		KeyHash:          keyHash,
+		Scopes:           reqBody.Scopes,
+		CreatedBy:        sql.NullString{String: adminID, Valid: true},
+	}
+	if reqBody.ExpiresInDays > 0 {
+		apiKey.ExpiresAt = sql.NullTime{Time: time.Now().AddDate(0, 0, reqBody.ExpiresInDays), Valid: true}
+	}
+
+	if err := s.account.CreateAPIKey(c.UserContext(), apiKey); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"key":     apiKey,
+		"api_key": key,
+	})
+}
+
+// handleRevokeAPIKey revokes an API key of a service account with immediate effect.
+func (s *APIServer) handleRevokeAPIKey(c *fiber.Ctx) error {
+	if err := s.account.RevokeAPIKey(c.UserContext(), c.Params("id"), c.Params("keyID")); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// serviceAccount retrieves the user with the given ID, reporting it as not found unless it is a service account.
+func (s *APIServer) serviceAccount(ctx context.Context, id string) (*models.User, error) {
+	u, err := s.account.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !u.ServiceAccount {
+		return nil, &models.Error{Kind: models.ErrNotFound, Message: fmt.Sprintf("service account with ID %s not found", id)}
+	}
+	return u, nil
+}
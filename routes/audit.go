@@ -0,0 +1,142 @@
+package routes
+
+import (
+	"crypto/rand"
+	"log"
+
+	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// requestIDHeader carries the ID of a request, taken from the client or a proxy when given and generated otherwise.
+	requestIDHeader = "X-Request-ID"
+
+	// maxRequestIDLength bounds request IDs accepted from clients.
+	maxRequestIDLength = 128
+)
+
+// requestID assigns every request an ID, stored in c.Locals("requestID") and echoed in the X-Request-ID
+// response header, so that audit events and logs can be matched to requests.
+func requestID(c *fiber.Ctx) error {
+	id := c.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = rand.Text()
+	}
+	c.Locals("requestID", id)
+	c.Set(requestIDHeader, id)
+	return c.Next()
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to record.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' || r == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// auditEvent describes an action of the caller's request for the audit log.
+// Changes of patient records hand it to the store, which records it in the same transaction as the change.
+func auditEvent(c *fiber.Ctx, action, patientID string, fields []string, detail string) *models.AuditEvent {
+	actorID, _ := c.Locals("userID").(string)
+	actorRole, _ := c.Locals("userRole").(string)
+	reqID, _ := c.Locals("requestID").(string)
+
+	return &models.AuditEvent{
+		ActorID:   actorID,
+		ActorRole: actorRole,
+		Action:    action,
+		PatientID: patientID,
+		Fields:    fields,
+		Detail:    detail,
+		RequestID: reqID,
+		IP:        c.IP(),
+	}
+}
+
+// audit appends an event about the caller's request to the audit log.
+// Handlers serving patient data write it before responding and refuse to respond when that fails.
+func (s *APIServer) audit(c *fiber.Ctx, action, patientID string, fields []string, detail string) error {
+	event := auditEvent(c, action, patientID, fields, detail)
+	if err := s.account.AppendAuditEvent(c.UserContext(), event); err != nil {
+		log.Printf("Failed to record audit event %s on patient %q by %s (request %s): %v", action, patientID, event.ActorID, event.RequestID, err)
+		return err
+	}
+	return nil
+}
+
+// changedPatientFields lists the fields that differ between two versions of a patient record.
+func changedPatientFields(before, after *models.Patient) []string {
+	fields := []string{}
+	if before.Name != after.Name {
+		fields = append(fields, "name")
+	}
+	if before.Age != after.Age {
+		fields = append(fields, "age")
+	}
+	if before.Gender != after.Gender {
+		fields = append(fields, "gender")
+	}
+	if before.Diagnosis != after.Diagnosis {
+		fields = append(fields, "diagnosis")
+	}
+	return fields
+}
+
+// auditFailed responds to a request whose access could not be recorded in the audit log.
+func auditFailed(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to record access in the audit log"})
+}
+
+// handleListAuditEvents retrieves a page of the audit log for compliance review, newest first,
+// optionally filtered by actor_id, patient_id, action and time range (from/to as RFC 3339 timestamps or dates).
+func (s *APIServer) handleListAuditEvents(c *fiber.Ctx) error {
+	filter := models.AuditFilter{
+		ActorID:   c.Query("actor_id"),
+		PatientID: c.Query("patient_id"),
+		Action:    c.Query("action"),
+	}
+
+	var err error
+	if filter.From, err = parseReportTime(c.Query("from")); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "from must be an RFC 3339 timestamp or a date (YYYY-MM-DD)"})
+	}
+	if filter.To, err = parseReportTime(c.Query("to")); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must be an RFC 3339 timestamp or a date (YYYY-MM-DD)"})
+	}
+
+	page := c.QueryInt("page", 1)     // Default to page 1
+	limit := c.QueryInt("limit", 100) // Default to 100 items per page
+
+	if page <= 0 {
+		page = 1
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	events, err := s.account.ListAuditEvents(c.UserContext(), filter, limit, (page-1)*limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(events)
+}
+
+// handleVerifyAuditLog checks the audit log's hash chain. A broken chain is reported with 409 Conflict.
+func (s *APIServer) handleVerifyAuditLog(c *fiber.Ctx) error {
+	result, err := s.account.VerifyAuditLog(c.UserContext())
+	if err != nil {
+		return err
+	}
+	if !result.Valid {
+		return c.Status(fiber.StatusConflict).JSON(result)
+	}
+	return c.JSON(result)
+}
@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// handleListCareTeam retrieves every assignment to a patient's care team, current and past.
+func (s *APIServer) handleListCareTeam(c *fiber.Ctx) error {
+	if _, err := s.storage.GetPatientByID(c.UserContext(), c.Params("id")); err != nil {
+		return err
+	}
+
+	members, err := s.storage.ListCareTeam(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(members)
+}
+
+// handleAddCareTeamMember assigns a clinician to a patient's care team, from starts_on (default today)
+// until ends_on (default open-ended). Dates are given as YYYY-MM-DD.
+func (s *APIServer) handleAddCareTeamMember(c *fiber.Ctx) error {
+	var reqBody struct {
+		UserID   string `json:"user_id"`
+		Role     string `json:"role"`
+		StartsOn string `json:"starts_on"`
+		EndsOn   string `json:"ends_on"`
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.UserID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "user_id is required."})
+	}
+
+	member := &models.CareTeamMember{
+		PatientID: c.Params("id"),
+		UserID:    reqBody.UserID,
+		Role:      strings.TrimSpace(reqBody.Role),
+		StartsOn:  time.Now().UTC().Truncate(24 * time.Hour),
+	}
+	if reqBody.StartsOn != "" {
+		startsOn, err := time.Parse(time.DateOnly, reqBody.StartsOn)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "starts_on must be a date (YYYY-MM-DD)"})
+		}
+		member.StartsOn = startsOn
+	}
+	endsOn, err := parseCareTeamEnd(reqBody.EndsOn)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ends_on must be a date (YYYY-MM-DD)"})
+	}
+	member.EndsOn = endsOn
+
+	if userID, ok := c.Locals("userID").(string); ok {
+		member.AssignedBy = sql.NullString{String: userID, Valid: true}
+	}
+
+	user, err := s.account.GetUserByID(c.UserContext(), member.UserID)
+	if err != nil {
+		return err
+	}
+	if user.ServiceAccount {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Service accounts cannot be assigned to care teams."})
+	}
+
+	if err := s.storage.AddCareTeamMember(c.UserContext(), member); err != nil {
+		return err
+	}
+	member.UserName = user.Name
+
+	return c.Status(fiber.StatusCreated).JSON(member)
+}
+
+// handleUpdateCareTeamMember changes the role or dates of a care team assignment. Ending an assignment
+// (setting ends_on) keeps it on record, unlike removing it; an empty ends_on makes it open-ended again.
+func (s *APIServer) handleUpdateCareTeamMember(c *fiber.Ctx) error {
+	var reqBody struct {
+		Role     *string `json:"role"`
+		StartsOn *string `json:"starts_on"`
+		EndsOn   *string `json:"ends_on"`
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.Role == nil && reqBody.StartsOn == nil && reqBody.EndsOn == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Nothing to update. Provide role, starts_on and/or ends_on."})
+	}
+
+	members, err := s.storage.ListCareTeam(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+	var member *models.CareTeamMember
+	for _, m := range members {
+		if m.ID == c.Params("memberID") {
+			member = m
+		}
+	}
+	if member == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Care team assignment not found"})
+	}
+
+	if reqBody.Role != nil {
+		member.Role = strings.TrimSpace(*reqBody.Role)
+	}
+	if reqBody.StartsOn != nil {
+		startsOn, err := time.Parse(time.DateOnly, *reqBody.StartsOn)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "starts_on must be a date (YYYY-MM-DD)"})
+		}
+		member.StartsOn = startsOn
+	}
+	if reqBody.EndsOn != nil {
+		if member.EndsOn, err = parseCareTeamEnd(*reqBody.EndsOn); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ends_on must be a date (YYYY-MM-DD)"})
+		}
+	}
+
+	if err := s.storage.UpdateCareTeamMember(c.UserContext(), member); err != nil {
+		return err
+	}
+
+	return c.JSON(member)
+}
+
+// handleRemoveCareTeamMember deletes a care team assignment, e.g. one made by mistake.
+func (s *APIServer) handleRemoveCareTeamMember(c *fiber.Ctx) error {
+	if err := s.storage.RemoveCareTeamMember(c.UserContext(), c.Params("id"), c.Params("memberID")); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// parseCareTeamEnd parses the optional last day of a care team assignment.
+func parseCareTeamEnd(value string) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	endsOn, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: endsOn, Valid: true}, nil
+}
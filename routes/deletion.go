@@ -0,0 +1,57 @@
+package routes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// handleListDeletedPatients retrieves a page of deleted patient records, most recently deleted first,
+// with who deleted them, when and why.
+func (s *APIServer) handleListDeletedPatients(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)    // Default to page 1
+	limit := c.QueryInt("limit", 20) // Default to 20 items per page
+
+	if page <= 0 {
+		page = 1
+	}
+	if limit <= 0 {
+		limit = 20
+	}
+
+	patients, err := s.storage.ListDeletedPatients(c.UserContext(), limit, (page-1)*limit)
+	if err != nil {
+		return err
+	}
+
+	returned := make([]string, len(patients))
+	for i, p := range patients {
+		returned[i] = p.ID
+	}
+	detail := fmt.Sprintf("deleted page=%d limit=%d returned=%s", page, limit, strings.Join(returned, ","))
+	if err := s.audit(c, models.AuditActionPatientList, "", nil, detail); err != nil {
+		return auditFailed(c)
+	}
+
+	return c.JSON(patients)
+}
+
+// handleUndeletePatient brings a deleted patient record back into use.
+func (s *APIServer) handleUndeletePatient(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	patient, err := s.storage.UndeletePatient(c.UserContext(), id, userID, auditEvent(c, models.AuditActionPatientUndelete, id, nil, ""))
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderETag, patientETag(patient.Version))
+	return c.JSON(patient)
+}
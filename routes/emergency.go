@@ -0,0 +1,150 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// minEmergencyAccessReasonLength is the shortest justification accepted for breaking the glass,
+// so that reviewers get more than "urgent".
+const minEmergencyAccessReasonLength = 20
+
+// handleBreakGlass grants the caller time-boxed read access to one patient record outside their normal
+// permissions. A written reason is mandatory; the grant and every request made under it are audited.
+func (s *APIServer) handleBreakGlass(c *fiber.Ctx) error {
+	var reqBody struct {
+		Reason string `json:"reason"`
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	reason := strings.TrimSpace(reqBody.Reason)
+	if len(reason) < minEmergencyAccessReasonLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("A reason of at least %d characters is required for emergency access.", minEmergencyAccessReasonLength),
+		})
+	}
+
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	patientID := c.Params("id")
+	if _, err := s.storage.GetPatientByID(c.UserContext(), patientID); err != nil {
+		return err
+	}
+
+	grant := &models.EmergencyAccess{
+		UserID:    userID,
+		PatientID: patientID,
+		Reason:    reason,
+		IP:        c.IP(),
+		ExpiresAt: time.Now().Add(s.tokens.EmergencyAccess),
+	}
+	event := auditEvent(c, models.AuditActionPatientEmergencyAccess, patientID, nil, "")
+	if err := s.account.CreateEmergencyAccess(c.UserContext(), grant, event); err != nil {
+		return err
+	}
+	// The reason is kept with the grant and not logged, since it often contains clinical details.
+	log.Printf("Emergency access to patient %s granted to user %s until %s", patientID, userID, grant.ExpiresAt.Format(time.RFC3339))
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Emergency access granted. This access is recorded and will be reviewed.",
+		"grant":   grant,
+	})
+}
+
+// handleEmergencyAccessReport lists emergency access grants for compliance review, optionally filtered by
+// user, patient and time range (from/to as RFC 3339 timestamps or dates). With format=csv it is returned as a CSV file.
+func (s *APIServer) handleEmergencyAccessReport(c *fiber.Ctx) error {
+	filter := models.EmergencyAccessFilter{
+		UserID:    c.Query("user_id"),
+		PatientID: c.Query("patient_id"),
+	}
+
+	var err error
+	if filter.From, err = parseReportTime(c.Query("from")); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "from must be an RFC 3339 timestamp or a date (YYYY-MM-DD)"})
+	}
+	if filter.To, err = parseReportTime(c.Query("to")); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must be an RFC 3339 timestamp or a date (YYYY-MM-DD)"})
+	}
+
+	page := c.QueryInt("page", 1)     // Default to page 1
+	limit := c.QueryInt("limit", 100) // Default to 100 items per page
+
+	if page <= 0 {
+		page = 1
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	grants, err := s.account.ListEmergencyAccesses(c.UserContext(), filter, limit, (page-1)*limit)
+	if err != nil {
+		return err
+	}
+
+	// The report names patients and the reasons given for reading their records, so it is audited like them.
+	returned := make([]string, len(grants))
+	for i, g := range grants {
+		returned[i] = g.ID
+	}
+	detail := fmt.Sprintf("user_id=%s from=%s to=%s page=%d limit=%d returned=%s",
+		filter.UserID, c.Query("from"), c.Query("to"), page, limit, strings.Join(returned, ","))
+	if err := s.audit(c, models.AuditActionPatientEmergencyAccessReport, filter.PatientID, nil, detail); err != nil {
+		return auditFailed(c)
+	}
+
+	if c.Query("format") != "csv" {
+		return c.JSON(grants)
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	header := []string{"Grant ID", "Granted At", "Expires At", "User ID", "User Name", "User Role",
+		"Patient ID", "Patient Name", "Reason", "IP", "Uses", "Last Used At"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, g := range grants {
+		lastUsed := ""
+		if g.LastUsedAt.Valid {
+			lastUsed = g.LastUsedAt.Time.Format(time.RFC3339)
+		}
+		row := []string{g.ID, g.CreatedAt.Format(time.RFC3339), g.ExpiresAt.Format(time.RFC3339), g.UserID, g.UserName, g.UserRole,
+			g.PatientID, g.PatientName, g.Reason, g.IP, strconv.Itoa(g.Uses), lastUsed}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	c.Set("Content-Type", "text/csv")
+	c.Set("Content-Disposition", `attachment; filename="emergency_access_report.csv"`)
+	return c.Send(buf.Bytes())
+}
+
+// parseReportTime parses an optional report boundary given as an RFC 3339 timestamp or a date.
+func parseReportTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
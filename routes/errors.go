@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// errorStatuses maps the kinds of storage errors to the HTTP status codes that report them.
+var errorStatuses = map[error]int{
+	models.ErrNotFound:           fiber.StatusNotFound,
+	models.ErrConflict:           fiber.StatusConflict,
+	models.ErrStaleVersion:       fiber.StatusPreconditionFailed,
+	models.ErrDuplicateEmail:     fiber.StatusConflict,
+	models.ErrConstraint:         fiber.StatusConflict,
+	models.ErrInvalidInput:       fiber.StatusBadRequest,
+	models.ErrInvalidCredentials: fiber.StatusUnauthorized,
+	models.ErrAccountDisabled:    fiber.StatusForbidden,
+	models.ErrUnavailable:        fiber.StatusServiceUnavailable,
+}
+
+// newApp creates the Fiber app serving the API, with handleError reporting the errors handlers return.
+func newApp() *fiber.App {
+	return fiber.New(fiber.Config{ErrorHandler: handleError})
+}
+
+// handleError turns an error returned by a handler into a JSON error response. Storage errors get the status
+// code of their kind; Fiber errors keep theirs. Requests that ran out of time fail with 504 and requests
+// cancelled because the server is shutting down with 503. Anything else is logged and reported as a 500
+// without details, so that database internals never reach clients.
+func handleError(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{"error": fiberErr.Message})
+	}
+
+	// The driver may report an aborted query as its own error, so the request's context is checked too.
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(c.UserContext().Err(), context.DeadlineExceeded) {
+		return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{"error": "Request timed out"})
+	}
+	if errors.Is(err, context.Canceled) {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Service unavailable"})
+	}
+
+	kind := models.Kind(err)
+	if status, ok := errorStatuses[kind]; ok {
+		// Database errors classified by their code only get the name of their kind.
+		message := kind.Error()
+		var storageErr *models.Error
+		if errors.As(err, &storageErr) {
+			message = storageErr.Message
+		}
+		return c.Status(status).JSON(fiber.Map{"error": message})
+	}
+
+	reqID, _ := c.Locals("requestID").(string)
+	log.Printf("%s %s failed (request %s): %v", c.Method(), c.Path(), reqID, err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+}
@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// patientETag formats the version of a patient record as a strong entity tag.
+func patientETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// anyVersion is returned by ifMatchVersion for "If-Match: *", which matches whatever version is current.
+const anyVersion = 0
+
+var (
+	errIfMatchMissing = errors.New("missing If-Match header")
+	errIfMatchInvalid = errors.New("invalid If-Match header")
+)
+
+// ifMatchVersion reads the patient record version a client expects to change from its If-Match header: an
+// entity tag served by patientETag, its weak form W/"N", or "*" for anyVersion. It returns errIfMatchMissing
+// when the header is absent and errIfMatchInvalid when it is present but none of these.
+func ifMatchVersion(c *fiber.Ctx) (int, error) {
+	tag := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if tag == "" {
+		return 0, errIfMatchMissing
+	}
+	if tag == "*" {
+		return anyVersion, nil
+	}
+	tag = strings.TrimPrefix(tag, "W/")
+	if len(tag) < 3 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, errIfMatchInvalid
+	}
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || version < 1 {
+		return 0, errIfMatchInvalid
+	}
+	return version, nil
+}
+
+// ifMatchFailed responds to a change of a patient record whose If-Match header could not be read:
+// 428 when it is missing and 400 when it is not an entity tag of a patient record.
+func ifMatchFailed(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errIfMatchMissing) {
+		return preconditionRequired(c)
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"error": `If-Match must be "*" or the ETag of a patient record version, e.g. "3"`,
+	})
+}
+
+// preconditionRequired responds to a change of a patient record that does not say which version it is based on.
+func preconditionRequired(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusPreconditionRequired).JSON(fiber.Map{
+		"error": "Changing a patient record requires an If-Match header with the ETag of the version being changed",
+	})
+}
+
+// preconditionFailed responds to a change of a patient record based on a version that is no longer current.
+// current is the current version when known, or 0.
+func preconditionFailed(c *fiber.Ctx, current int) error {
+	if current > 0 {
+		c.Set(fiber.HeaderETag, patientETag(current))
+	}
+	return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+		"error": "The patient record was changed by someone else; fetch it again and reapply your changes",
+	})
+}
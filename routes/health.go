@@ -0,0 +1,135 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ReadinessCheck is a dependency that has to be available before the server can serve requests. The error
+// of Check is shown to anyone who can reach /readyz, so checks log the details and return a summary.
+type ReadinessCheck struct {
+	Name  string                          // Key of the check in the /readyz response, e.g. "database".
+	Check func(ctx context.Context) error // Reports why the dependency is not ready; ctx ends after Timeouts.Readiness.
+}
+
+// checkResult is the outcome of one readiness check in the /readyz response.
+type checkResult struct {
+	Status         string `json:"status"` // "ok" or "failed".
+	Error          string `json:"error,omitempty"`
+	DurationMillis int64  `json:"duration_ms"`
+}
+
+// BuildInfo describes the running binary in the /version response.
+type BuildInfo struct {
+	Version    string `json:"version"`               // Release, set with -ldflags "-X main.version=..."; "dev" otherwise.
+	Commit     string `json:"commit,omitempty"`      // Git commit the binary was built from.
+	CommitTime string `json:"commit_time,omitempty"` // Time of that commit.
+	Modified   bool   `json:"modified"`              // The working tree had uncommitted changes.
+	GoVersion  string `json:"go_version"`
+}
+
+// ReadBuildInfo returns the build information of the running binary with the given release version, taking
+// the commit from the version control information the Go toolchain embeds in binaries.
+func ReadBuildInfo(version string) BuildInfo {
+	info := BuildInfo{Version: version}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = bi.GoVersion
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.time":
+			info.CommitTime = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// registerHealthRoutes mounts the probes of orchestrators and load balancers and the build information.
+// They are registered ahead of every other route and middleware, so that they never need credentials
+// and stay cheap enough to be polled every few seconds.
+func (s *APIServer) registerHealthRoutes(app *fiber.App) {
+	app.Get("/healthz", s.handleHealth)
+	app.Get("/readyz", s.handleReady)
+	app.Get("/version", s.handleVersion)
+}
+
+// handleHealth answers the liveness probe: the process is running and serving HTTP. It checks no dependencies,
+// so that an unavailable database makes the service unready rather than getting it restarted.
+func (s *APIServer) handleHealth(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// handleReady answers the readiness probe by running every readiness check concurrently, each with its own
+// timeout, which also applies to checks that ignore their context. It reports every check and answers
+// 503 Service Unavailable when any of them failed.
+func (s *APIServer) handleReady(c *fiber.Ctx) error {
+	checks := append([]ReadinessCheck{{Name: "signing_keys", Check: s.checkSigningKeys}}, s.readiness...)
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]checkResult, len(checks))
+		ready   = true
+	)
+	for _, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(c.UserContext(), s.timeouts.Readiness)
+			defer cancel()
+
+			start := time.Now()
+			done := make(chan error, 1)
+			go func() { done <- check.Check(ctx) }()
+			var err error
+			select {
+			case err = <-done:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("timed out after %s", s.timeouts.Readiness)
+			}
+			result := checkResult{Status: "ok", DurationMillis: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status, result.Error = "failed", err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[check.Name] = result
+			ready = ready && err == nil
+		}()
+	}
+	wg.Wait()
+
+	if !ready {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "not_ready", "checks": results})
+	}
+	return c.JSON(fiber.Map{"status": "ready", "checks": results})
+}
+
+// checkSigningKeys reports whether a key is loaded to sign tokens with, without which nobody can log in.
+func (s *APIServer) checkSigningKeys(ctx context.Context) error {
+	if s.keys == nil || s.keys.ActiveKeyID() == "" {
+		return errors.New("no signing key loaded")
+	}
+	return nil
+}
+
+// handleVersion reports the build information of the running binary.
+func (s *APIServer) handleVersion(c *fiber.Ctx) error {
+	return c.JSON(s.build)
+}
@@ -0,0 +1,191 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	auth "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/auth"
+	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// fieldChange is the change of one field between two versions of a patient record.
+type fieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"` // Value in the older version; null when unset or when there is no older version.
+	To    interface{} `json:"to"`   // Value in the newer version; null when unset.
+}
+
+// patientFieldPermissions maps the fields of a patient record to the permission needed to change them.
+var patientFieldPermissions = map[string]string{
+	"name":      auth.PermissionPatientUpdate,
+	"age":       auth.PermissionPatientUpdate,
+	"gender":    auth.PermissionPatientUpdate,
+	"diagnosis": auth.PermissionPatientDiagnose,
+}
+
+// patientVersionValues lists the fields of a version with their values, in a fixed order.
+func patientVersionValues(v *models.PatientVersion) []fieldChange {
+	var diagnosis interface{}
+	if v.Diagnosis.Valid {
+		diagnosis = v.Diagnosis.String
+	}
+	return []fieldChange{
+		{Field: "name", To: v.Name},
+		{Field: "age", To: v.Age},
+		{Field: "gender", To: v.Gender},
+		{Field: "diagnosis", To: diagnosis},
+	}
+}
+
+// diffPatientVersions lists the fields that differ between two versions; from may be nil.
+func diffPatientVersions(from, to *models.PatientVersion) []fieldChange {
+	var oldValues []fieldChange
+	if from != nil {
+		oldValues = patientVersionValues(from)
+	}
+
+	changes := []fieldChange{}
+	for i, value := range patientVersionValues(to) {
+		var old interface{}
+		if oldValues != nil {
+			old = oldValues[i].To
+		}
+		if old != value.To {
+			changes = append(changes, fieldChange{Field: value.Field, From: old, To: value.To})
+		}
+	}
+	return changes
+}
+
+// currentPatientVersion describes the current state of a patient record as a version, for comparisons.
+func currentPatientVersion(p *models.Patient) *models.PatientVersion {
+	return &models.PatientVersion{PatientID: p.ID, Name: p.Name, Age: p.Age, Gender: p.Gender, Diagnosis: p.Diagnosis}
+}
+
+// handleGetPatientHistory lists every version of a patient record, newest first.
+func (s *APIServer) handleGetPatientHistory(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	versions, err := s.storage.ListPatientVersions(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Patient details not found"})
+	}
+
+	if err := s.audit(c, models.AuditActionPatientHistory, id, nil, "all versions"); err != nil {
+		return auditFailed(c)
+	}
+
+	return c.JSON(versions)
+}
+
+// handleGetPatientVersion retrieves one version of a patient record together with the fields changed
+// since the previous version, or since the version given with ?compare=.
+func (s *APIServer) handleGetPatientVersion(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	number, err := c.ParamsInt("version")
+	if err != nil || number < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Version must be a positive number."})
+	}
+	compare := c.QueryInt("compare", number-1)
+	if compare < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "compare must be a version number."})
+	}
+
+	version, err := s.storage.GetPatientVersion(c.UserContext(), id, number)
+	if err != nil {
+		return err
+	}
+
+	var base *models.PatientVersion
+	if compare > 0 {
+		if base, err = s.storage.GetPatientVersion(c.UserContext(), id, compare); err != nil {
+			return err
+		}
+	}
+
+	if err := s.audit(c, models.AuditActionPatientHistory, id, nil, fmt.Sprintf("version %d compared to %d", number, compare)); err != nil {
+		return auditFailed(c)
+	}
+
+	return c.JSON(fiber.Map{
+		"version":     version,
+		"compared_to": compare,
+		"changes":     diffPatientVersions(base, version),
+	})
+}
+
+// handleRestorePatientVersion sets a patient record back to an earlier version. Callers may only restore
+// fields they are allowed to edit: demographics need patient:update, the diagnosis patient:diagnose.
+// If-Match must carry the ETag of the version being replaced.
+func (s *APIServer) handleRestorePatientVersion(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	number, err := c.ParamsInt("version")
+	if err != nil || number < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Version must be a positive number."})
+	}
+
+	expected, err := ifMatchVersion(c)
+	if err != nil {
+		return ifMatchFailed(c, err)
+	}
+
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	current, err := s.storage.GetPatientByID(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+	if expected == anyVersion {
+		expected = current.Version
+	} else if current.Version != expected {
+		return preconditionFailed(c, current.Version)
+	}
+	version, err := s.storage.GetPatientVersion(c.UserContext(), id, number)
+	if err != nil {
+		return err
+	}
+
+	changes := diffPatientVersions(currentPatientVersion(current), version)
+	if len(changes) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("The patient record already matches version %d.", number)})
+	}
+	fields := make([]string, len(changes))
+	var forbidden []string
+	for i, change := range changes {
+		fields[i] = change.Field
+		if !auth.HasPermission(c, patientFieldPermissions[change.Field]) {
+			forbidden = append(forbidden, change.Field)
+		}
+	}
+	if len(forbidden) > 0 {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Access denied: Restoring this version changes fields you may not edit: " + strings.Join(forbidden, ", "),
+		})
+	}
+
+	event := auditEvent(c, models.AuditActionPatientRestore, id, fields, fmt.Sprintf("restored version %d", number))
+	patient, err := s.storage.RestorePatientVersion(c.UserContext(), id, number, expected, userID, event)
+	if err != nil {
+		if errors.Is(err, models.ErrStaleVersion) {
+			return preconditionFailed(c, 0)
+		}
+		return err
+	}
+
+	c.Set(fiber.HeaderETag, patientETag(patient.Version))
+	return c.JSON(fiber.Map{
+		"message": fmt.Sprintf("Version %d restored", number),
+		"patient": patient,
+		"changes": changes,
+	})
+}
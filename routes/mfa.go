@@ -0,0 +1,281 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	auth "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/auth"
+	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// secondFactorRequest is the body accepted wherever a user proves possession of their second factor.
+// Exactly one of Code (from the authenticator app) or RecoveryCode is expected.
+type secondFactorRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// handleLoginMFA completes a login for a user with TOTP enabled.
+// It exchanges the MFA token returned by /login and a TOTP or recovery code for an access and refresh token.
+func (s *APIServer) handleLoginMFA(c *fiber.Ctx) error {
+	var reqBody struct {
+		MFAToken string `json:"mfa_token"`
+		secondFactorRequest
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.MFAToken == "" || (reqBody.Code == "" && reqBody.RecoveryCode == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "MFA token and a code or recovery code are required."})
+	}
+
+	userID, tokenID, expiresAt, err := auth.ParseMFAToken(s.keys, reqBody.MFAToken)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+	}
+
+	// Challenge tokens are single use; a completed login revokes its token.
+	revoked, err := s.account.IsAccessTokenRevoked(c.UserContext(), tokenID)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+	}
+
+	// Six-digit codes are easy to guess without throttling; limit attempts per user and per client IP.
+	throttleKeys := []string{auth.MFAThrottleKey(userID), auth.IPThrottleKey(c.IP())}
+	attempt, retryAfter, err := s.startLoginAttempt(c.UserContext(), throttleKeys...)
+	if err != nil {
+		return err
+	}
+	if retryAfter > 0 {
+		return tooManyLoginAttempts(c, retryAfter)
+	}
+
+	ok, err := s.verifySecondFactor(c.UserContext(), userID, reqBody.secondFactorRequest)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := s.loginFailed(c.UserContext(), attempt); err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid two-factor authentication code"})
+	}
+
+	if err := s.loginSucceeded(c.UserContext(), attempt); err != nil {
+		return err
+	}
+
+	user, err := s.account.GetUserByID(c.UserContext(), userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+		}
+		return err
+	}
+	if user.Disabled {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Account is disabled"})
+	}
+
+	if err := s.account.RevokeAccessToken(c.UserContext(), tokenID, expiresAt); err != nil {
+		return err
+	}
+
+	resp, err := s.issueTokens(c, user, []string{auth.AuthMethodPassword, auth.AuthMethodOTP})
+	if err != nil {
+		return err
+	}
+
+	resp["message"] = "Login successful"
+	resp["role"] = user.Role
+	return c.JSON(resp)
+}
+
+// handleGetTOTPStatus reports whether the caller has TOTP enabled and whether their role requires it.
+func (s *APIServer) handleGetTOTPStatus(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	cfg, err := s.account.GetTOTPConfig(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+
+	required, _ := c.Locals("mfaRequired").(bool)
+	return c.JSON(fiber.Map{
+		"enabled":  cfg.Enabled,
+		"required": required,
+	})
+}
+
+// handleStartTOTPEnrolment generates a new TOTP secret for the caller.
+// The secret only becomes active once confirmed with a valid code via POST /api/me/mfa/totp/verify.
+func (s *APIServer) handleStartTOTPEnrolment(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	user, err := s.account.GetUserByID(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+	if user.TOTPEnabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return err
+	}
+
+	if err := s.account.SetPendingTOTPSecret(c.UserContext(), userID, secret); err != nil {
+		if errors.Is(err, models.ErrConflict) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+		}
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPURI(user.Email, secret),
+	})
+}
+
+// handleConfirmTOTPEnrolment enables TOTP for the caller once they prove their authenticator produces valid codes.
+// The response contains the recovery codes; they are only ever shown once.
+func (s *APIServer) handleConfirmTOTPEnrolment(c *fiber.Ctx) error {
+	var reqBody struct {
+		Code string `json:"code"`
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Code is required."})
+	}
+
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	cfg, err := s.account.GetTOTPConfig(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+	if cfg.Enabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+	}
+	if cfg.Secret == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No enrolment in progress. Start one with POST /api/me/mfa/totp."})
+	}
+
+	step, valid := auth.VerifyTOTP(cfg.Secret, reqBody.Code, time.Now())
+	if !valid {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid two-factor authentication code"})
+	}
+
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+
+	if err := s.account.EnableTOTP(c.UserContext(), userID, step, hashes); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+		}
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"message":        "Two-factor authentication enabled. Store the recovery codes somewhere safe; they will not be shown again.",
+		"recovery_codes": codes,
+	})
+}
+
+// handleDisableTOTP removes the caller's TOTP enrolment after they prove possession of the second factor.
+// Users whose role requires two-factor authentication cannot disable it.
+func (s *APIServer) handleDisableTOTP(c *fiber.Ctx) error {
+	var reqBody secondFactorRequest
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.Code == "" && reqBody.RecoveryCode == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A code or recovery code is required."})
+	}
+
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	if required, _ := c.Locals("mfaRequired").(bool); required {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Two-factor authentication is required for your role and cannot be disabled"})
+	}
+
+	// A stolen access token must not allow guessing the code to strip the second factor, so attempts are
+	// throttled like those of the second login step and count against the same limits.
+	throttleKeys := []string{auth.MFAThrottleKey(userID), auth.IPThrottleKey(c.IP())}
+	attempt, retryAfter, err := s.startLoginAttempt(c.UserContext(), throttleKeys...)
+	if err != nil {
+		return err
+	}
+	if retryAfter > 0 {
+		return tooManyLoginAttempts(c, retryAfter)
+	}
+
+	valid, err := s.verifySecondFactor(c.UserContext(), userID, reqBody)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		if err := s.loginFailed(c.UserContext(), attempt); err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid two-factor authentication code"})
+	}
+
+	if err := s.loginSucceeded(c.UserContext(), attempt); err != nil {
+		return err
+	}
+	if err := s.account.DisableTOTP(c.UserContext(), userID); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "Two-factor authentication disabled"})
+}
+
+// verifySecondFactor checks a TOTP or recovery code for the user and consumes it, so it cannot be used twice.
+func (s *APIServer) verifySecondFactor(ctx context.Context, userID string, req secondFactorRequest) (bool, error) {
+	if req.RecoveryCode != "" {
+		return s.account.ConsumeRecoveryCode(ctx, userID, auth.HashRecoveryCode(req.RecoveryCode))
+	}
+
+	cfg, err := s.account.GetTOTPConfig(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if !cfg.Enabled {
+		return false, nil
+	}
+
+	step, valid := auth.VerifyTOTP(cfg.Secret, req.Code, time.Now())
+	if !valid {
+		return false, nil
+	}
+	return s.account.ConsumeTOTPStep(ctx, userID, step)
+}
@@ -0,0 +1,189 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	auth "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/auth"
+	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/mail"
+	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// forgotPasswordMessage is returned by /password/forgot whether or not the account exists.
+const forgotPasswordMessage = "If an account with that email exists, a password reset link has been sent."
+
+// handleChangePassword lets the caller change their password after confirming the current one.
+// Other sessions of the user are ended; the caller receives a fresh access and refresh token.
+func (s *APIServer) handleChangePassword(c *fiber.Ctx) error {
+	var reqBody struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.CurrentPassword == "" || reqBody.NewPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Current and new password are required."})
+	}
+	if reqBody.CurrentPassword == reqBody.NewPassword {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "New password must differ from the current password."})
+	}
+
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	valid, err := s.account.VerifyPassword(c.UserContext(), userID, reqBody.CurrentPassword)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Current password is incorrect"})
+	}
+
+	user, err := s.account.GetUserByID(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+	if err := s.passwords.Validate(reqBody.NewPassword, user.Email); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := s.account.UpdatePassword(c.UserContext(), userID, reqBody.NewPassword); err != nil {
+		return err
+	}
+	s.sendPasswordChangedNotice(user)
+
+	// Changing the password ended every session, including the caller's; start a new one.
+	authMethods, _ := c.Locals("authMethods").([]string)
+	resp, err := s.issueTokens(c, user, authMethods)
+	if err != nil {
+		return err
+	}
+
+	resp["message"] = "Password changed successfully"
+	return c.JSON(resp)
+}
+
+// handleForgotPassword e-mails a single-use password reset link.
+// The response is the same whether or not the account exists, so it cannot be used to discover accounts.
+func (s *APIServer) handleForgotPassword(c *fiber.Ctx) error {
+	var reqBody struct {
+		Email string `json:"email"`
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Email is required."})
+	}
+
+	user, err := s.account.GetUserByEmail(c.UserContext(), reqBody.Email)
+	if err != nil {
+		if !errors.Is(err, models.ErrNotFound) {
+			return err
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": forgotPasswordMessage})
+	}
+	if user.Disabled || user.ServiceAccount {
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": forgotPasswordMessage})
+	}
+
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	ttl := s.tokens.PasswordReset
+	rt := &models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.account.CreatePasswordResetToken(c.UserContext(), rt); err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hello %s,\n\nSomeone asked to reset the password of your account. "+
+		"If this was you, use the following link or token within %s:\n\n%s\n\n"+
+		"If you did not ask for this, you can ignore this message; your password has not been changed.\n",
+		user.Name, ttl, s.passwordResetLink(token))
+
+	// Delivery failures are logged rather than returned, since the response must not depend on the account.
+	if err := s.mailer.Send(mail.Message{To: user.Email, Subject: "Reset your password", Body: body}); err != nil {
+		log.Printf("Failed to send password reset mail to user %s: %v", user.ID, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": forgotPasswordMessage})
+}
+
+// handleResetPassword sets a new password using a token from a password reset mail.
+func (s *APIServer) handleResetPassword(c *fiber.Ctx) error {
+	var reqBody struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.Token == "" || reqBody.NewPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Token and new password are required."})
+	}
+
+	tokenHash := auth.HashToken(reqBody.Token)
+
+	// The policy needs the account's email, so look up whose token this is before using it.
+	rt, err := s.account.GetPasswordResetToken(c.UserContext(), tokenHash)
+	if err != nil {
+		return resetPasswordError(c, err)
+	}
+	user, err := s.account.GetUserByID(c.UserContext(), rt.UserID)
+	if err != nil {
+		return err
+	}
+	if err := s.passwords.Validate(reqBody.NewPassword, user.Email); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if _, err := s.account.ResetPassword(c.UserContext(), tokenHash, reqBody.NewPassword); err != nil {
+		return resetPasswordError(c, err)
+	}
+	s.sendPasswordChangedNotice(user)
+
+	return c.JSON(fiber.Map{"message": "Password has been reset. Please log in with your new password."})
+}
+
+// resetPasswordError writes the response for a reset token that could not be used.
+func resetPasswordError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, models.ErrInvalidInput) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired reset token"})
+	}
+	return err
+}
+
+// passwordResetLink returns what the user needs to reset their password:
+// a link when PASSWORD_RESET_URL is configured, otherwise the bare token.
+func (s *APIServer) passwordResetLink(token string) string {
+	if s.resetURL == "" {
+		return token
+	}
+	return s.resetURL + "?token=" + url.QueryEscape(token)
+}
+
+// sendPasswordChangedNotice tells the user that their password changed, so an unexpected change does not go unnoticed.
+func (s *APIServer) sendPasswordChangedNotice(u *models.User) {
+	body := fmt.Sprintf("Hello %s,\n\nThe password of your account was changed and you have been logged out on other devices. "+
+		"If you did not do this, contact your administrator immediately.\n", u.Name)
+
+	if err := s.mailer.Send(mail.Message{To: u.Email, Subject: "Your password was changed", Body: body}); err != nil {
+		log.Printf("Failed to send password change notice to user %s: %v", u.ID, err)
+	}
+}
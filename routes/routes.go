@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	auth "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/auth"
+	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/mail"
 	"github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
 	"github.com/gofiber/fiber/v2"
 )
@@ -18,55 +21,156 @@ type APIServer struct {
 	listenAddr string
 	storage    models.Storage
 	account    models.Account
+	keys       *auth.KeyRing
+	mailer     mail.Mailer
+	throttle   auth.LoginThrottlePolicy
+	passwords  auth.PasswordPolicy
+	tokens     auth.TokenLifetimes
+	oidc       *auth.OIDCProvider // Single sign-on provider; nil when single sign-on is not configured.
+	resetURL   string             // Base URL of the page where users reset their password; the token is appended as ?token=.
+	timeouts   RequestTimeouts
+	dbStats    func() sql.DBStats // Statistics of the database connection pool; nil when not available.
+	readiness  []ReadinessCheck   // Dependencies checked by /readyz besides the signing keys.
+	build      BuildInfo
+}
+
+// Options are the settings of an APIServer. Policies, lifetimes and timeouts left at their zero value take their
+// defaults.
+type Options struct {
+	ListenAddr string // Address to listen on, e.g. ":3000".
+	Passwords  auth.PasswordPolicy
+	Throttle   auth.LoginThrottlePolicy
+	Tokens     auth.TokenLifetimes
+	Timeouts   RequestTimeouts
+	ResetURL   string             // Base URL of the page where users reset their password; the token is appended as ?token=.
+	OIDC       *auth.OIDCProvider // Single sign-on provider; nil disables single sign-on.
+	DBStats    func() sql.DBStats // Statistics of the database connection pool, usually (*sql.DB).Stats.
+	Readiness  []ReadinessCheck   // Dependencies checked by /readyz, such as the database.
+	Build      BuildInfo          // Reported by /version.
 }
 
 // NewAPIServer creates a new APIServer instance.
-func NewAPIServer(listenAddr string, storage models.Storage, account models.Account) *APIServer {
+func NewAPIServer(storage models.Storage, account models.Account, keys *auth.KeyRing, mailer mail.Mailer, opts Options) *APIServer {
+	if opts.Passwords.MinLength == 0 && opts.Passwords.MaxLength == 0 {
+		denyList := opts.Passwords.DenyList
+		opts.Passwords = auth.DefaultPasswordPolicy()
+		opts.Passwords.DenyList = denyList
+	}
+	if opts.Throttle == (auth.LoginThrottlePolicy{}) {
+		opts.Throttle = auth.DefaultLoginThrottlePolicy()
+	}
+	if opts.Tokens == (auth.TokenLifetimes{}) {
+		opts.Tokens = auth.DefaultTokenLifetimes()
+	}
+	if opts.Timeouts == (RequestTimeouts{}) {
+		opts.Timeouts = DefaultRequestTimeouts()
+	}
+
 	return &APIServer{
-		listenAddr: listenAddr,
+		listenAddr: opts.ListenAddr,
 		storage:    storage,
 		account:    account,
+		keys:       keys,
+		mailer:     mailer,
+		throttle:   opts.Throttle,
+		passwords:  opts.Passwords,
+		tokens:     opts.Tokens,
+		oidc:       opts.OIDC,
+		resetURL:   opts.ResetURL,
+		timeouts:   opts.Timeouts,
+		dbStats:    opts.DBStats,
+		readiness:  opts.Readiness,
+		build:      opts.Build,
 	}
 }
 
 // Run starts the Fiber API server and registers the routes.
 func (s *APIServer) Run() {
-	app := fiber.New()
+	app := newApp()
+
+	s.registerHealthRoutes(app)
+	s.registerRoutes(app, auth.JWTMiddleware(s.keys, s.account))
+
+	log.Printf("Server listening on %s", s.listenAddr)
+	log.Fatal(app.Listen(s.listenAddr))
+}
+
+// registerRoutes mounts every route on app, protecting the /api group (and /logout) with authn.
+func (s *APIServer) registerRoutes(app *fiber.App, authn fiber.Handler) {
+	app.Use(requestID, withTimeout(s.timeouts.Default))
 
 	// Public routes for user registration and login
 	app.Post("/register", s.handleCreateUserAccount)
 	app.Post("/login", s.handleLoginUserAccount)
+	app.Post("/login/mfa", s.handleLoginMFA)
+	app.Get("/login/oidc", s.handleStartOIDCLogin)
+	app.Get("/login/oidc/callback", s.handleOIDCCallback)
+	app.Post("/token/refresh", s.handleRefreshToken)
+	app.Post("/logout", authn, s.handleLogout)
+	app.Post("/password/forgot", s.handleForgotPassword)
+	app.Post("/password/reset", s.handleResetPassword)
 
-	// API group protected by JWT authentication middleware
-	authGroup := app.Group("/api", auth.JWTMiddleware)
-
-	// Receptionist-specific routes
-	receptionistGroup := authGroup.Group("/receptionist")
-	receptionistGroup.Use(auth.RoleMiddleware("receptionist"))
-	{
-		receptionistGroup.Post("/patients", s.handleAddPatient)
-		receptionistGroup.Get("/patients", s.handleGetPatients)
-		receptionistGroup.Get("/patients/:id", s.handleGetPatientByID)
-		receptionistGroup.Put("/patients/:id", s.handleUpdatePatientByID)
-		receptionistGroup.Delete("/patients/:id", s.handleDeletePatientByID)
-		receptionistGroup.Get("/patients/:id/export/csv", s.handleExportPatientCSV)
-	}
-
-	// Doctor-specific routes
-	doctorGroup := authGroup.Group("/doctor")
-	doctorGroup.Use(auth.RoleMiddleware("doctor"))
-	{
-		doctorGroup.Get("/patients", s.handleGetPatients)
-		doctorGroup.Get("/patients/:id", s.handleGetPatientByID)
-		doctorGroup.Put("/patients/:id", s.handleUpdatePatientByDoctor)
-		doctorGroup.Get("/patients/:id/export/csv", s.handleExportPatientCSV)
-	}
+	// Public signing keys so that other services can verify our tokens
+	app.Get("/.well-known/jwks.json", s.handleJWKS)
 
-	log.Printf("Server listening on %s", s.listenAddr)
-	log.Fatal(app.Listen(s.listenAddr))
+	// API group protected by JWT authentication middleware
+	authGroup := app.Group("/api", authn)
+
+	// The caller's own account; reachable without a second factor so that users can enrol one
+	meGroup := authGroup.Group("/me")
+	meGroup.Put("/password", s.handleChangePassword)
+	meGroup.Get("/mfa/totp", s.handleGetTOTPStatus)
+	meGroup.Post("/mfa/totp", s.handleStartTOTPEnrolment)
+	meGroup.Post("/mfa/totp/verify", s.handleConfirmTOTPEnrolment)
+	meGroup.Delete("/mfa/totp", s.handleDisableTOTP)
+	meGroup.Get("/sessions", s.handleListMySessions)
+	meGroup.Delete("/sessions/:id", s.handleRevokeMySession)
+
+	// Patient routes; what a caller may do is decided by the permissions granted to their role
+	patientGroup := authGroup.Group("/patients", auth.RequireMFA)
+	patientGroup.Post("", auth.RequirePermission(auth.PermissionPatientCreate), s.handleAddPatient)
+	patientGroup.Get("", auth.RequirePermission(auth.PermissionPatientRead), s.handleGetPatients)
+	patientGroup.Get("/:id", auth.RequirePatientPermission(s.storage, s.account, auth.PermissionPatientRead), s.handleGetPatientByID)
+	patientGroup.Put("/:id", auth.RequireAnyPermission(auth.PermissionPatientUpdate, auth.PermissionPatientDiagnose), auth.RequireCareTeam(s.storage), s.handleUpdatePatient)
+	patientGroup.Delete("/:id", auth.RequirePermission(auth.PermissionPatientDelete), auth.RequireCareTeam(s.storage), s.handleDeletePatientByID)
+	patientGroup.Get("/:id/export/csv", auth.RequirePermission(auth.PermissionPatientExport), auth.RequireCareTeam(s.storage), withTimeout(s.timeouts.Export), s.handleExportPatientCSV)
+	patientGroup.Get("/:id/history", auth.RequirePatientPermission(s.storage, s.account, auth.PermissionPatientRead), s.handleGetPatientHistory)
+	patientGroup.Get("/:id/history/:version", auth.RequirePatientPermission(s.storage, s.account, auth.PermissionPatientRead), s.handleGetPatientVersion)
+	patientGroup.Post("/:id/history/:version/restore", auth.RequireAnyPermission(auth.PermissionPatientUpdate, auth.PermissionPatientDiagnose), auth.RequireCareTeam(s.storage), s.handleRestorePatientVersion)
+	patientGroup.Post("/:id/emergency-access", auth.RequirePermission(auth.PermissionPatientEmergencyAccess), s.handleBreakGlass)
+
+	// Administration of user accounts
+	adminGroup := authGroup.Group("/admin", auth.RequireMFA)
+	adminGroup.Get("/users", auth.RequirePermission(auth.PermissionUserRead), s.handleListUsers)
+	adminGroup.Post("/users", auth.RequirePermission(auth.PermissionUserManage), s.handleAdminCreateUser)
+	adminGroup.Get("/users/:id", auth.RequirePermission(auth.PermissionUserRead), s.handleGetUser)
+	adminGroup.Patch("/users/:id", auth.RequirePermission(auth.PermissionUserManage), s.handleUpdateUser)
+	adminGroup.Delete("/users/:id", auth.RequirePermission(auth.PermissionUserManage), s.handleDeleteUser)
+	adminGroup.Delete("/users/:id/mfa", auth.RequirePermission(auth.PermissionUserManage), s.handleResetUserMFA)
+	adminGroup.Post("/users/:id/unlock", auth.RequirePermission(auth.PermissionUserManage), s.handleUnlockUser)
+	adminGroup.Get("/users/:id/sessions", auth.RequirePermission(auth.PermissionUserRead), s.handleListUserSessions)
+	adminGroup.Delete("/users/:id/sessions", auth.RequirePermission(auth.PermissionUserManage), s.handleRevokeUserSessions)
+	adminGroup.Post("/invitations", auth.RequirePermission(auth.PermissionUserManage), s.handleCreateInvitation)
+	adminGroup.Get("/lockouts", auth.RequirePermission(auth.PermissionUserRead), s.handleListLockouts)
+	adminGroup.Post("/lockouts/ip/:ip/unlock", auth.RequirePermission(auth.PermissionUserManage), s.handleUnlockIP)
+	adminGroup.Post("/service-accounts", auth.RequirePermission(auth.PermissionUserManage), s.handleCreateServiceAccount)
+	adminGroup.Get("/service-accounts/:id/keys", auth.RequirePermission(auth.PermissionUserRead), s.handleListAPIKeys)
+	adminGroup.Post("/service-accounts/:id/keys", auth.RequirePermission(auth.PermissionUserManage), s.handleCreateAPIKey)
+	adminGroup.Delete("/service-accounts/:id/keys/:keyID", auth.RequirePermission(auth.PermissionUserManage), s.handleRevokeAPIKey)
+	adminGroup.Get("/emergency-access", auth.RequirePermission(auth.PermissionAuditRead), s.handleEmergencyAccessReport)
+	adminGroup.Get("/audit", auth.RequirePermission(auth.PermissionAuditRead), withTimeout(s.timeouts.Audit), s.handleListAuditEvents)
+	adminGroup.Get("/audit/verify", auth.RequirePermission(auth.PermissionAuditRead), withTimeout(s.timeouts.Audit), s.handleVerifyAuditLog)
+	adminGroup.Get("/database/stats", auth.RequirePermission(auth.PermissionSystemRead), s.handleDatabaseStats)
+	adminGroup.Get("/patients/deleted", auth.RequirePermission(auth.PermissionPatientManageDeleted), s.handleListDeletedPatients)
+	adminGroup.Post("/patients/:id/undelete", auth.RequirePermission(auth.PermissionPatientManageDeleted), s.handleUndeletePatient)
+	adminGroup.Get("/patients/:id/care-team", auth.RequirePermission(auth.PermissionCareTeamManage), s.handleListCareTeam)
+	adminGroup.Post("/patients/:id/care-team", auth.RequirePermission(auth.PermissionCareTeamManage), s.handleAddCareTeamMember)
+	adminGroup.Patch("/patients/:id/care-team/:memberID", auth.RequirePermission(auth.PermissionCareTeamManage), s.handleUpdateCareTeamMember)
+	adminGroup.Delete("/patients/:id/care-team/:memberID", auth.RequirePermission(auth.PermissionCareTeamManage), s.handleRemoveCareTeamMember)
 }
 
-// handleAddPatient handles the addition of a new patient by a receptionist.
+// handleAddPatient handles the registration of a new patient.
+// A diagnosis may only be supplied by callers holding the patient:diagnose permission.
 func (s *APIServer) handleAddPatient(c *fiber.Ctx) error {
 	var p models.Patient
 
@@ -93,15 +197,18 @@ func (s *APIServer) handleAddPatient(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Patient gender is required."})
 	}
 
-	// Receptionists cannot set diagnosis
-	if tempPatient.Diagnosis != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Receptionists cannot set patient diagnosis. Diagnosis is added by doctors."})
+	// Only callers allowed to diagnose may set a diagnosis
+	if tempPatient.Diagnosis != nil && !auth.HasPermission(c, auth.PermissionPatientDiagnose) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Access denied: " + auth.PermissionPatientDiagnose + " permission required"})
 	}
 
 	p.Name = tempPatient.Name
 	p.Age = tempPatient.Age
 	p.Gender = tempPatient.Gender
 	p.Diagnosis = sql.NullString{} // Initialize diagnosis as null
+	if tempPatient.Diagnosis != nil && *tempPatient.Diagnosis != "" {
+		p.Diagnosis = sql.NullString{String: *tempPatient.Diagnosis, Valid: true}
+	}
 
 	userID, ok := c.Locals("userID").(string)
 	if !ok {
@@ -109,16 +216,50 @@ func (s *APIServer) handleAddPatient(c *fiber.Ctx) error {
 	}
 	p.CreatedBy = userID
 
-	if err := s.storage.AddPatient(&p); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fmt.Sprintf("Failed to add patient: %v", err)})
+	fields := []string{"name", "age", "gender"}
+	if p.Diagnosis.Valid {
+		fields = append(fields, "diagnosis")
+	}
+	if err := s.storage.AddPatient(c.UserContext(), &p, auditEvent(c, models.AuditActionPatientCreate, "", fields, "")); err != nil {
+		return err
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(p)
 }
 
-// handleGetPatients retrieves a list of patients, with optional filtering by name and pagination.
+// handleGetPatients retrieves a list of patients, with optional filtering by name, by who registered, last
+// updated or diagnosed them (created_by, updated_by, diagnosed_by) and by when (created_from/created_to,
+// updated_from/updated_to as RFC 3339 timestamps or dates), and pagination.
+// Callers without patient:read_all only see the patients of their current care teams.
 func (s *APIServer) handleGetPatients(c *fiber.Ctx) error {
-	nameQuery := c.Query("name")
+	filter := models.PatientFilter{
+		Name:        c.Query("name"),
+		CreatedBy:   c.Query("created_by"),
+		UpdatedBy:   c.Query("updated_by"),
+		DiagnosedBy: c.Query("diagnosed_by"),
+	}
+	times := []struct {
+		param string
+		dest  *time.Time
+	}{
+		{"created_from", &filter.CreatedFrom},
+		{"created_to", &filter.CreatedTo},
+		{"updated_from", &filter.UpdatedFrom},
+		{"updated_to", &filter.UpdatedTo},
+	}
+	for _, t := range times {
+		var err error
+		if *t.dest, err = parseReportTime(c.Query(t.param)); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": t.param + " must be an RFC 3339 timestamp or a date (YYYY-MM-DD)"})
+		}
+	}
+	if !auth.HasPermission(c, auth.PermissionPatientReadAll) {
+		userID, ok := c.Locals("userID").(string)
+		if !ok || userID == "" {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+		}
+		filter.CareTeamMemberID = userID
+	}
 
 	page := c.QueryInt("page", 1)    // Default to page 1
 	limit := c.QueryInt("limit", 20) // Default to 20 items per page
@@ -131,9 +272,19 @@ func (s *APIServer) handleGetPatients(c *fiber.Ctx) error {
 	}
 
 	offset := (page - 1) * limit
-	patients, err := s.storage.GetPatients(nameQuery, limit, offset)
+	patients, err := s.storage.GetPatients(c.UserContext(), filter, limit, offset)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
+	}
+
+	returned := make([]string, len(patients))
+	for i, p := range patients {
+		returned[i] = p.ID
+	}
+	// Names searched for are patient data themselves, so only the use of a name filter is recorded.
+	detail := fmt.Sprintf("name_filter=%t page=%d limit=%d returned=%s", filter.Name != "", page, limit, strings.Join(returned, ","))
+	if err := s.audit(c, models.AuditActionPatientList, "", nil, detail); err != nil {
+		return auditFailed(c)
 	}
 
 	return c.JSON(patients)
@@ -143,21 +294,40 @@ func (s *APIServer) handleGetPatients(c *fiber.Ctx) error {
 func (s *APIServer) handleGetPatientByID(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	patient, err := s.storage.GetPatientByID(id)
+	patient, err := s.storage.GetPatientByID(c.UserContext(), id)
 	if err != nil {
-		// More specific error handling for "not found" cases
-		if strings.Contains(err.Error(), "not found") {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Patient details not found"})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
+	}
+
+	detail := ""
+	if grantID, ok := c.Locals("emergencyAccessID").(string); ok {
+		detail = "emergency access grant " + grantID
+	}
+	if err := s.audit(c, models.AuditActionPatientRead, patient.ID, nil, detail); err != nil {
+		return auditFailed(c)
 	}
 
+	c.Set(fiber.HeaderETag, patientETag(patient.Version))
 	return c.JSON(patient)
 }
 
-// handleUpdatePatientByID handles updating patient details by a receptionist.
-// Receptionists cannot update the diagnosis field.
-func (s *APIServer) handleUpdatePatientByID(c *fiber.Ctx) error {
+// handleUpdatePatient dispatches a patient update based on the caller's permissions:
+// callers with patient:update edit demographics, callers with only patient:diagnose edit the diagnosis.
+func (s *APIServer) handleUpdatePatient(c *fiber.Ctx) error {
+	switch {
+	case auth.HasPermission(c, auth.PermissionPatientUpdate):
+		return s.handleUpdatePatientDetails(c)
+	case auth.HasPermission(c, auth.PermissionPatientDiagnose):
+		return s.handleUpdatePatientDiagnosis(c)
+	default:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied: Insufficient permissions for this action"})
+	}
+}
+
+// handleUpdatePatientDetails handles updating patient details (name, age, gender).
+// The diagnosis can only be changed as well when the caller also holds patient:diagnose.
+// If-Match must carry the ETag of the version being changed.
+func (s *APIServer) handleUpdatePatientDetails(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	// Temporary struct for partial updates, using pointers for optional fields
@@ -172,19 +342,26 @@ func (s *APIServer) handleUpdatePatientByID(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
-	// Prevent receptionists from updating diagnosis
-	if tempPatientUpdate.Diagnosis != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Receptionists cannot update patient diagnosis. Diagnosis can only be updated by doctors."})
+	// Prevent callers without patient:diagnose from updating diagnosis
+	canDiagnose := auth.HasPermission(c, auth.PermissionPatientDiagnose)
+	if tempPatientUpdate.Diagnosis != nil && !canDiagnose {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Access denied: " + auth.PermissionPatientDiagnose + " permission required"})
 	}
 
-	existingPatient, err := s.storage.GetPatientByID(id)
+	version, err := ifMatchVersion(c)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fmt.Sprintf("Failed to fetch existing patient for update: %v", err)})
+		return ifMatchFailed(c, err)
 	}
 
+	existingPatient, err := s.storage.GetPatientByID(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+	if version != anyVersion && existingPatient.Version != version {
+		return preconditionFailed(c, existingPatient.Version)
+	}
+	before := *existingPatient
+
 	// Update fields if provided in the request body
 	if tempPatientUpdate.Name != nil {
 		existingPatient.Name = *tempPatientUpdate.Name
@@ -204,39 +381,77 @@ func (s *APIServer) handleUpdatePatientByID(c *fiber.Ctx) error {
 	} else {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Patient gender is required for update."})
 	}
+	if tempPatientUpdate.Diagnosis != nil && canDiagnose {
+		existingPatient.Diagnosis = sql.NullString{String: *tempPatientUpdate.Diagnosis, Valid: *tempPatientUpdate.Diagnosis != ""}
+	}
 
 	userID, ok := c.Locals("userID").(string)
 	if !ok {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found in context for update."})
 	}
-	existingPatient.CreatedBy = userID // Update the `CreatedBy` field to the user performing the update
+	existingPatient.UpdatedBy = userID // Record the user performing the update; who registered the patient is kept
 
-	if err := s.storage.UpdatePatient(existingPatient); err != nil {
-		if strings.Contains(err.Error(), "not found for update") {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Patient with provided ID not found for update."})
+	event := auditEvent(c, models.AuditActionPatientUpdate, existingPatient.ID, changedPatientFields(&before, existingPatient), "")
+	if err := s.storage.UpdatePatient(c.UserContext(), existingPatient, event); err != nil {
+		if errors.Is(err, models.ErrStaleVersion) {
+			return preconditionFailed(c, 0)
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fmt.Sprintf("Failed to update patient details: %v", err)})
+		return err
 	}
 
+	c.Set(fiber.HeaderETag, patientETag(existingPatient.Version))
 	return c.JSON(existingPatient)
 }
 
-// handleDeletePatientByID handles the deletion of a patient by their ID.
+// handleDeletePatientByID handles the deletion of a patient by their ID. The record is only marked as deleted,
+// with the caller and the mandatory reason given as {"reason": "..."}, and kept for the retention period.
+// If-Match must carry the ETag of the version being deleted.
 func (s *APIServer) handleDeletePatientByID(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	if err := s.storage.DeletePatientByID(id); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	var reqBody struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	reason := strings.TrimSpace(reqBody.Reason)
+	if reason == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A reason is required to delete a patient record."})
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return ifMatchFailed(c, err)
+	}
+
+	if version == anyVersion {
+		patient, err := s.storage.GetPatientByID(c.UserContext(), id)
+		if err != nil {
+			return err
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		version = patient.Version
+	}
+
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	event := auditEvent(c, models.AuditActionPatientDelete, id, nil, "")
+	if err := s.storage.DeletePatientByID(c.UserContext(), id, version, userID, reason, event); err != nil {
+		if errors.Is(err, models.ErrStaleVersion) {
+			return preconditionFailed(c, 0)
+		}
+		return err
 	}
 
 	return c.SendStatus(fiber.StatusNoContent) // 204 No Content for successful deletion
 }
 
-// handleUpdatePatientByDoctor allows a doctor to update a patient's diagnosis.
-func (s *APIServer) handleUpdatePatientByDoctor(c *fiber.Ctx) error {
+// handleUpdatePatientDiagnosis allows a clinician to update a patient's diagnosis.
+// Any other fields in the request body are ignored. If-Match must carry the ETag of the version being changed.
+func (s *APIServer) handleUpdatePatientDiagnosis(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var reqBody struct {
@@ -251,28 +466,36 @@ func (s *APIServer) handleUpdatePatientByDoctor(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Diagnosis field is required for update."})
 	}
 
-	existingPatient, err := s.storage.GetPatientByID(id)
+	version, err := ifMatchVersion(c)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fmt.Sprintf("Failed to fetch existing patient: %v", err)})
+		return ifMatchFailed(c, err)
+	}
+
+	existingPatient, err := s.storage.GetPatientByID(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+	if version != anyVersion && existingPatient.Version != version {
+		return preconditionFailed(c, existingPatient.Version)
 	}
+	before := *existingPatient
 
 	existingPatient.Diagnosis = sql.NullString{String: reqBody.Diagnosis, Valid: true}
 
 	userID, ok := c.Locals("userID").(string) // Get the doctor's ID
 	if ok && userID != "" {
-		existingPatient.CreatedBy = userID // Update `CreatedBy` to the doctor who last modified diagnosis
+		existingPatient.UpdatedBy = userID // The store also records the doctor as having made the diagnosis
 	}
 
-	if err := s.storage.UpdatePatient(existingPatient); err != nil {
-		if strings.Contains(err.Error(), "not found for update") {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Patient with provided ID not found for update."})
+	event := auditEvent(c, models.AuditActionPatientUpdate, existingPatient.ID, changedPatientFields(&before, existingPatient), "")
+	if err := s.storage.UpdatePatient(c.UserContext(), existingPatient, event); err != nil {
+		if errors.Is(err, models.ErrStaleVersion) {
+			return preconditionFailed(c, 0)
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fmt.Sprintf("Failed to update diagnosis: %v", err)})
+		return err
 	}
 
+	c.Set(fiber.HeaderETag, patientETag(existingPatient.Version))
 	return c.JSON(existingPatient)
 }
 
@@ -280,19 +503,16 @@ func (s *APIServer) handleUpdatePatientByDoctor(c *fiber.Ctx) error {
 func (s *APIServer) handleExportPatientCSV(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	patient, err := s.storage.GetPatientByID(id)
+	patient, err := s.storage.GetPatientByID(c.UserContext(), id)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fmt.Sprintf("Failed to retrieve patient for CSV export: %v", err)})
+		return err
 	}
 
 	var buf bytes.Buffer
 	writer := csv.NewWriter(&buf)
 
 	// Write CSV header
-	header := []string{"ID", "Name", "Age", "Gender", "Diagnosis", "Created By"}
+	header := []string{"ID", "Name", "Age", "Gender", "Diagnosis", "Created By", "Created At", "Updated By", "Updated At", "Diagnosed By", "Diagnosed At"}
 	if err := writer.Write(header); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to write CSV header"})
 	}
@@ -302,6 +522,10 @@ func (s *APIServer) handleExportPatientCSV(c *fiber.Ctx) error {
 	if patient.Diagnosis.Valid {
 		diagnosisValue = patient.Diagnosis.String
 	}
+	diagnosedAt := ""
+	if patient.DiagnosedAt.Valid {
+		diagnosedAt = patient.DiagnosedAt.Time.Format(time.RFC3339)
+	}
 
 	// Write patient data row
 	dataRow := []string{
@@ -311,6 +535,11 @@ func (s *APIServer) handleExportPatientCSV(c *fiber.Ctx) error {
 		patient.Gender,
 		diagnosisValue,
 		patient.CreatedBy,
+		patient.CreatedAt.Format(time.RFC3339),
+		patient.UpdatedBy,
+		patient.UpdatedAt.Format(time.RFC3339),
+		patient.DiagnosedBy.String,
+		diagnosedAt,
 	}
 
 	if err := writer.Write(dataRow); err != nil {
@@ -319,7 +548,11 @@ func (s *APIServer) handleExportPatientCSV(c *fiber.Ctx) error {
 
 	writer.Flush()
 	if err := writer.Error(); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fmt.Sprintf("Failed to flush CSV writer: %v", err)})
+		return err
+	}
+
+	if err := s.audit(c, models.AuditActionPatientExport, patient.ID, nil, "csv"); err != nil {
+		return auditFailed(c)
 	}
 
 	// Set appropriate headers for CSV download
@@ -329,17 +562,50 @@ func (s *APIServer) handleExportPatientCSV(c *fiber.Ctx) error {
 	return c.Send(buf.Bytes())
 }
 
-// handleCreateUserAccount handles the registration of a new user account.
+// handleCreateUserAccount handles self-registration of a new user account.
+// Registration requires an invitation token created by an administrator; the account receives
+// the invitation's role. The only exception is the very first account, which becomes an administrator.
 func (s *APIServer) handleCreateUserAccount(c *fiber.Ctx) error {
-	var u models.User
+	var reqBody struct {
+		Name        string `json:"name"`
+		Email       string `json:"email"`
+		Password    string `json:"password"`
+		InviteToken string `json:"invite_token"`
+	}
 
-	if err := c.BodyParser(&u); err != nil {
+	if err := c.BodyParser(&reqBody); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	if reqBody.Name == "" || reqBody.Email == "" || reqBody.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Name, email and password are required."})
+	}
+	if err := s.passwords.Validate(reqBody.Password, reqBody.Email); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	err := s.account.CreateUserAccount(&u)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	u := models.User{
+		Name:     reqBody.Name,
+		Email:    reqBody.Email,
+		Password: reqBody.Password,
+	}
+
+	if reqBody.InviteToken != "" {
+		if err := s.account.AcceptInvitation(c.UserContext(), auth.HashToken(reqBody.InviteToken), &u); err != nil {
+			switch {
+			case errors.Is(err, models.ErrNotFound), errors.Is(err, models.ErrInvalidInput):
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Invalid or expired invitation"})
+			}
+			return err
+		}
+	} else {
+		// Without an invitation, only the bootstrap administrator may register.
+		u.Role = adminRole
+		if err := s.account.CreateFirstUserAccount(c.UserContext(), &u); err != nil {
+			if errors.Is(err, models.ErrConflict) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Registration requires an invitation from an administrator"})
+			}
+			return err
+		}
 	}
 
 	u.Password = "" // Clear password before sending response for security
@@ -350,6 +616,7 @@ func (s *APIServer) handleCreateUserAccount(c *fiber.Ctx) error {
 }
 
 // handleLoginUserAccount handles user login and generates a JWT token upon successful authentication.
+// For users with two-factor authentication enabled it returns an MFA challenge token instead.
 func (s *APIServer) handleLoginUserAccount(c *fiber.Ctx) error {
 	var user models.LoginUser
 
@@ -357,19 +624,230 @@ func (s *APIServer) handleLoginUserAccount(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
-	dbuser, err := s.account.LoginUserAccount(&user)
+	// Attempts are throttled per account and per client IP, whether or not the account exists.
+	throttleKeys := []string{auth.AccountThrottleKey(user.Email), auth.IPThrottleKey(c.IP())}
+	attempt, retryAfter, err := s.startLoginAttempt(c.UserContext(), throttleKeys...)
+	if err != nil {
+		return err
+	}
+	if retryAfter > 0 {
+		return tooManyLoginAttempts(c, retryAfter)
+	}
+
+	dbuser, err := s.account.LoginUserAccount(c.UserContext(), &user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidCredentials):
+			if err := s.loginFailed(c.UserContext(), attempt); err != nil {
+				return err
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": invalidCredentialsMessage})
+		case errors.Is(err, models.ErrAccountDisabled):
+			// The password was right, so the attempt does not count as a failure.
+			if err := s.loginSucceeded(c.UserContext(), attempt); err != nil {
+				return err
+			}
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Account is disabled"})
+		}
+		return err
+	}
+
+	if err := s.loginSucceeded(c.UserContext(), attempt); err != nil {
+		return err
+	}
+
+	// Users with TOTP enabled only get a short-lived challenge token; POST /login/mfa completes the login.
+	if dbuser.TOTPEnabled {
+		mfaToken, err := auth.GenerateMFAToken(s.keys, dbuser)
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{
+			"message":      "Two-factor authentication required",
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		})
+	}
+
+	resp, err := s.issueTokens(c, dbuser, []string{auth.AuthMethodPassword})
+	if err != nil {
+		return err
+	}
+
+	resp["message"] = "Login successful"
+	resp["role"] = dbuser.Role
+	return c.JSON(resp)
+}
+
+// handleRefreshToken exchanges a valid refresh token for a new access token and a rotated refresh token.
+// Presenting a refresh token that was already rotated revokes its whole family, since it indicates theft.
+func (s *APIServer) handleRefreshToken(c *fiber.Ctx) error {
+	var reqBody struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := c.BodyParser(&reqBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if reqBody.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Refresh token is required."})
+	}
+
+	current, err := s.account.GetRefreshToken(c.UserContext(), auth.HashToken(reqBody.RefreshToken))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired refresh token"})
+		}
+		return err
+	}
+
+	// A revoked token being presented again means it was replayed; kill the whole family.
+	if current.RevokedAt.Valid {
+		if err := s.account.RevokeRefreshTokenFamily(c.UserContext(), current.FamilyID); err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired refresh token"})
+	}
+	if time.Now().After(current.ExpiresAt) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired refresh token"})
+	}
+
+	user, err := s.account.GetUserByID(c.UserContext(), current.UserID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired refresh token"})
+		}
+		return err
+	}
+	if user.Disabled {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Account is disabled"})
+	}
+
+	accessToken, err := auth.GenerateToken(s.keys, user, current.FamilyID, current.AuthMethods, s.tokens.Access)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
 	}
 
-	tokenString, err := auth.GenerateToken(dbuser)
+	refreshToken, refreshHash, err := auth.GenerateOpaqueToken()
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
+	}
+
+	next := &models.RefreshToken{
+		TokenHash: refreshHash,
+		ExpiresAt: time.Now().Add(s.tokens.Refresh),
+	}
+	if err := s.account.RotateRefreshToken(c.UserContext(), current, next); err != nil {
+		// Lost a race with another use of the same token: treat it as a replay.
+		if errors.Is(err, models.ErrConflict) {
+			if err := s.account.RevokeRefreshTokenFamily(c.UserContext(), current.FamilyID); err != nil {
+				return err
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired refresh token"})
+		}
+		return err
 	}
 
 	return c.JSON(fiber.Map{
-		"token":   "Bearer " + tokenString, // Prefix token with "Bearer " for common usage
-		"message": "Login successful",
-		"role":    dbuser.Role,
+		"token":         "Bearer " + accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(s.tokens.Access.Seconds()),
 	})
 }
+
+// handleLogout ends the caller's session, revoking its access and refresh tokens.
+// A refresh token in the body is revoked as well, for clients that still send one.
+func (s *APIServer) handleLogout(c *fiber.Ctx) error {
+	var reqBody struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// The body is optional; only reject it when it is present but malformed.
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&reqBody); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	if reqBody.RefreshToken != "" {
+		rt, err := s.account.GetRefreshToken(c.UserContext(), auth.HashToken(reqBody.RefreshToken))
+		if err != nil && !errors.Is(err, models.ErrNotFound) {
+			return err
+		}
+		// Silently ignore unknown tokens and tokens belonging to someone else.
+		if err == nil && rt.UserID == userID {
+			if err := s.account.RevokeRefreshTokenFamily(c.UserContext(), rt.FamilyID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if sessionID, _ := c.Locals("sessionID").(string); sessionID != "" {
+		if err := s.account.RevokeSession(c.UserContext(), userID, sessionID); err != nil && !errors.Is(err, models.ErrNotFound) {
+			return err
+		}
+	}
+
+	tokenID, _ := c.Locals("tokenID").(string)
+	expiresAt, _ := c.Locals("tokenExpiresAt").(time.Time)
+	if tokenID != "" {
+		if err := s.account.RevokeAccessToken(c.UserContext(), tokenID, expiresAt); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(fiber.Map{"message": "Logged out successfully"})
+}
+
+// handleJWKS publishes the public keys that currently verify access tokens as a JSON Web Key Set.
+func (s *APIServer) handleJWKS(c *fiber.Ctx) error {
+	c.Set("Cache-Control", "public, max-age=300")
+	return c.JSON(s.keys.JWKS())
+}
+
+// issueTokens starts a new session for the device making the request (i.e. a new login) and creates its
+// access token and the first refresh token of its family.
+// authMethods records how the user authenticated and is carried over to tokens obtained by refreshing.
+func (s *APIServer) issueTokens(c *fiber.Ctx, u *models.User, authMethods []string) (fiber.Map, error) {
+	session := &models.Session{
+		UserID:    u.ID,
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IP:        c.IP(),
+		ExpiresAt: time.Now().Add(s.tokens.Refresh),
+	}
+	if err := s.account.CreateSession(c.UserContext(), session); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := auth.GenerateToken(s.keys, u, session.ID, authMethods, s.tokens.Access)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, refreshHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &models.RefreshToken{
+		UserID:      u.ID,
+		FamilyID:    session.ID,
+		TokenHash:   refreshHash,
+		ExpiresAt:   session.ExpiresAt,
+		AuthMethods: authMethods,
+	}
+	if err := s.account.CreateRefreshToken(c.UserContext(), rt); err != nil {
+		return nil, err
+	}
+
+	return fiber.Map{
+		"token":         "Bearer " + accessToken, // Prefix token with "Bearer " for common usage
+		"refresh_token": refreshToken,
+		"expires_in":    int(s.tokens.Access.Seconds()),
+	}, nil
+}
@@ -0,0 +1,72 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// handleListMySessions retrieves the caller's active sessions, i.e. the devices they are signed in on.
+// The session making the request is flagged as current.
+func (s *APIServer) handleListMySessions(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	sessions, err := s.account.ListSessions(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+
+	current, _ := c.Locals("sessionID").(string)
+	for _, sess := range sessions {
+		sess.Current = sess.ID == current
+	}
+
+	return c.JSON(sessions)
+}
+
+// handleRevokeMySession signs the caller out of one of their sessions, e.g. a workstation they forgot to log out of.
+func (s *APIServer) handleRevokeMySession(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Authenticated user ID not found"})
+	}
+
+	if err := s.account.RevokeSession(c.UserContext(), userID, c.Params("id")); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleListUserSessions retrieves the active sessions of a user account.
+func (s *APIServer) handleListUserSessions(c *fiber.Ctx) error {
+	if _, err := s.account.GetUserByID(c.UserContext(), c.Params("id")); err != nil {
+		return err
+	}
+
+	sessions, err := s.account.ListSessions(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(sessions)
+}
+
+// handleRevokeUserSessions signs a user out on every device, e.g. after a lost tablet or a suspected compromise.
+// Their access tokens stop working immediately; they can log in again unless the account is also disabled.
+func (s *APIServer) handleRevokeUserSessions(c *fiber.Ctx) error {
+	if _, err := s.account.GetUserByID(c.UserContext(), c.Params("id")); err != nil {
+		return err
+	}
+
+	revoked, err := s.account.RevokeUserSessions(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"message":          "Sessions terminated",
+		"revoked_sessions": revoked,
+	})
+}
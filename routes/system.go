@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// databaseStats is the JSON form of sql.DBStats.
+type databaseStats struct {
+	MaxOpenConnections int   `json:"max_open_connections"` // 0 means unlimited.
+	OpenConnections    int   `json:"open_connections"`
+	InUse              int   `json:"in_use"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"wait_count"`       // Queries that had to wait for a free connection.
+	WaitDurationMillis int64 `json:"wait_duration_ms"` // Total time spent waiting for free connections.
+	MaxIdleClosed      int64 `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64 `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64 `json:"max_lifetime_closed"`
+}
+
+// handleDatabaseStats reports the statistics of the database connection pool, so that monitoring can tell
+// whether the pool is sized right: a growing wait count means requests queue for connections.
+func (s *APIServer) handleDatabaseStats(c *fiber.Ctx) error {
+	if s.dbStats == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Database statistics are not available")
+	}
+
+	st := s.dbStats()
+	return c.JSON(databaseStats{
+		MaxOpenConnections: st.MaxOpenConnections,
+		OpenConnections:    st.OpenConnections,
+		InUse:              st.InUse,
+		Idle:               st.Idle,
+		WaitCount:          st.WaitCount,
+		WaitDurationMillis: st.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      st.MaxIdleClosed,
+		MaxIdleTimeClosed:  st.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  st.MaxLifetimeClosed,
+	})
+}
@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// invalidCredentialsMessage is returned for every failed password check, whether or not the account exists.
+const invalidCredentialsMessage = "Invalid email or password"
+
+// loginRetryAfter returns how long the caller has to wait before attempting to log in with any of the given throttle keys.
+func (s *APIServer) loginRetryAfter(ctx context.Context, keys ...string) (time.Duration, error) {
+	now := time.Now()
+
+	var retryAfter time.Duration
+	for _, key := range keys {
+		t, err := s.account.GetLoginThrottle(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if wait := s.throttle.RetryAfter(t, now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	return retryAfter, nil
+}
+
+// startLoginAttempt throttles an attempt to log in with the given throttle keys. An attempt that may go ahead is
+// counted against every key before its credentials are checked, so a burst of parallel attempts cannot get past
+// the limit while the earlier ones are still being checked; loginFailed or loginSucceeded settles the count.
+// Otherwise it returns how long the caller has to wait, and a refused attempt stays counted.
+func (s *APIServer) startLoginAttempt(ctx context.Context, keys ...string) ([]*models.LoginThrottle, time.Duration, error) {
+	retryAfter, err := s.loginRetryAfter(ctx, keys...)
+	if err != nil || retryAfter > 0 {
+		return nil, retryAfter, err
+	}
+
+	attempt := make([]*models.LoginThrottle, 0, len(keys))
+	for _, key := range keys {
+		t, err := s.account.RecordLoginAttempt(ctx, key, s.throttle.FailureWindow)
+		if err != nil {
+			return nil, 0, err
+		}
+		// The attempt reaching the limit locks the key when it fails; those racing it are not checked at all.
+		if s.throttle.OverLimit(t) {
+			return nil, s.throttle.LockoutDuration, nil
+		}
+		attempt = append(attempt, t)
+	}
+	return attempt, 0, nil
+}
+
+// loginFailed settles an attempt whose credentials were wrong. It stays counted, and keys that reached their
+// limit are locked out.
+func (s *APIServer) loginFailed(ctx context.Context, attempt []*models.LoginThrottle) error {
+	now := time.Now()
+
+	for _, t := range attempt {
+		alreadyLocked := t.LockedUntil.Valid && t.LockedUntil.Time.After(now)
+		if alreadyLocked || !s.throttle.ShouldLock(t) {
+			continue
+		}
+
+		if err := s.account.LockLogin(ctx, t.Key, now.Add(s.throttle.LockoutDuration), t.Failures); err != nil {
+			return err
+		}
+		log.Printf("Locked out %s for %s after %d failed login attempts", t.Key, s.throttle.LockoutDuration, t.Failures)
+	}
+	return nil
+}
+
+// loginSucceeded settles an attempt whose credentials were right. The failures of the first key, the account or
+// user logging in, are cleared. The attempt is only taken back from the other keys: one valid account must not
+// reset the budget of an address guessing others.
+func (s *APIServer) loginSucceeded(ctx context.Context, attempt []*models.LoginThrottle) error {
+	if err := s.account.ClearLoginFailures(ctx, attempt[0].Key); err != nil {
+		return err
+	}
+	for _, t := range attempt[1:] {
+		if err := s.account.ForgiveLoginAttempt(ctx, t.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tooManyLoginAttempts rejects a throttled login attempt.
+// The message is the same for delays and lockouts, and for existing and unknown accounts.
+func tooManyLoginAttempts(c *fiber.Ctx, retryAfter time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Too many failed login attempts. Try again later."})
+}
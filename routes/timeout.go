@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequestTimeouts bounds how long a request may spend in handlers and the database. When a timeout elapses,
+// the request's context is cancelled, which aborts the running query, and the request fails with 504.
+type RequestTimeouts struct {
+	Default time.Duration // Every request not covered below.
+	Export  time.Duration // CSV exports of patient records.
+	Audit   time.Duration // Audit log queries and verification, which may read the whole log.
+
+	// Readiness bounds each check of /readyz, so that a hanging dependency is reported as failed
+	// before the orchestrator's probe gives up.
+	Readiness time.Duration
+}
+
+// DefaultRequestTimeouts returns the timeouts used unless configured otherwise.
+func DefaultRequestTimeouts() RequestTimeouts {
+	return RequestTimeouts{
+		Default:   10 * time.Second,
+		Export:    30 * time.Second,
+		Audit:     2 * time.Minute,
+		Readiness: 2 * time.Second,
+	}
+}
+
+// withTimeout gives the rest of the handler chain a context that expires after d, available through
+// c.UserContext(). It replaces a context set by an earlier withTimeout, so routes can have a longer
+// timeout than their group.
+func withTimeout(d time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.Context(), d)
+		defer cancel()
+
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	config "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/config"
+	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+)
+
+// Building blocks of the fake people and diagnoses created by "seed".
+var (
+	seedFirstNames = []string{"Aisha", "Ben", "Carlos", "Chloe", "David", "Elena", "Fatima", "George", "Hannah", "Ibrahim",
+		"Isla", "James", "Julia", "Kenji", "Leila", "Liam", "Maria", "Mohammed", "Nora", "Oliver", "Priya", "Rosa",
+		"Samuel", "Sofia", "Thomas", "Yusuf", "Zara"}
+	seedLastNames = []string{"Ahmed", "Brown", "Chen", "Davies", "Evans", "Fernandez", "Garcia", "Hughes", "Ito", "Jones",
+		"Khan", "Kowalski", "Lee", "Martin", "Nguyen", "Okafor", "Patel", "Roberts", "Singh", "Smith", "Taylor",
+		"Walker", "Williams", "Wilson"}
+	seedGenders   = []string{"Female", "Male", "Female", "Male", "Other"}
+	seedDiagnoses = []string{"Hypertension", "Type 2 diabetes", "Asthma", "Seasonal influenza", "Migraine",
+		"Iron deficiency anaemia", "Osteoarthritis of the knee", "Acute bronchitis", "Hypothyroidism",
+		"Generalised anxiety disorder", "Lower back pain", "Urinary tract infection", "Atrial fibrillation",
+		"Gastro-oesophageal reflux disease", "Sprained ankle"}
+)
+
+// seedCommand fills the database with fake staff and patients for development and demos. A third of the
+// staff are receptionists, who register the patients; the rest are doctors, who diagnose most patients and
+// are put on their care teams. Every change is audited as if it had been made through the API. The same
+// seed produces the same staff, whose existing accounts are reused, so running it again adds patients for them.
+func seedCommand(ctx context.Context, cfg *config.Config, db *sql.DB, store *models.PostgresStore, args []string) int {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	staffCount := flags.Int("staff", 9, "number of staff accounts")
+	patientCount := flags.Int("patients", 50, "number of patient records")
+	seed := flags.Int64("seed", 1, "seed of the random generator")
+	password := flags.String("password", "seeded-password", "password of every staff account")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *staffCount < 2 || *patientCount < 0 {
+		fmt.Fprint(os.Stderr, "seed needs at least 2 staff accounts and a non-negative number of patients\n")
+		return 2
+	}
+
+	rng := rand.New(rand.NewSource(*seed))
+
+	var receptionists, doctors []*models.User
+	for i := 0; i < *staffCount; i++ {
+		role := "doctor"
+		if i%3 == 0 {
+			role = "receptionist"
+		}
+		u, err := seedStaff(ctx, store, rng, i, role, *password)
+		if err != nil {
+			log.Printf("Failed to create staff account: %v", err)
+			return 1
+		}
+		if role == "receptionist" {
+			receptionists = append(receptionists, u)
+		} else {
+			doctors = append(doctors, u)
+		}
+	}
+
+	for i := 0; i < *patientCount; i++ {
+		if err := seedPatient(ctx, store, rng, receptionists, doctors); err != nil {
+			log.Printf("Failed to create patient: %v", err)
+			return 1
+		}
+	}
+
+	log.Printf("Seeded %d receptionists, %d doctors and %d patients; staff log in with password %q",
+		len(receptionists), len(doctors), *patientCount, *password)
+	return 0
+}
+
+// seedStaff creates the i-th fake staff account, or returns it when an earlier run created it already.
+func seedStaff(ctx context.Context, store *models.PostgresStore, rng *rand.Rand, i int, role, password string) (*models.User, error) {
+	first := seedFirstNames[rng.Intn(len(seedFirstNames))]
+	last := seedLastNames[rng.Intn(len(seedLastNames))]
+	u := &models.User{
+		Name:     first + " " + last,
+		Email:    fmt.Sprintf("%s.%s.%d@staff.example.com", strings.ToLower(first), strings.ToLower(last), i+1),
+		Password: password,
+		Role:     role,
+	}
+	if role == "doctor" {
+		u.Name = "Dr " + u.Name
+	}
+
+	err := store.CreateUserAccount(ctx, u)
+	if errors.Is(err, models.ErrDuplicateEmail) {
+		return store.GetUserByEmail(ctx, u.Email)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// seedPatient registers a fake patient by a random receptionist, puts a random doctor on their care team
+// and, for most patients, lets that doctor record a diagnosis.
+func seedPatient(ctx context.Context, store *models.PostgresStore, rng *rand.Rand, receptionists, doctors []*models.User) error {
+	receptionist := receptionists[rng.Intn(len(receptionists))]
+	doctor := doctors[rng.Intn(len(doctors))]
+
+	p := &models.Patient{
+		Name:      seedFirstNames[rng.Intn(len(seedFirstNames))] + " " + seedLastNames[rng.Intn(len(seedLastNames))],
+		Age:       uint(1 + rng.Intn(95)),
+		Gender:    seedGenders[rng.Intn(len(seedGenders))],
+		CreatedBy: receptionist.ID,
+	}
+	if err := store.AddPatient(ctx, p, seedAuditEvent(receptionist, models.AuditActionPatientCreate, "", []string{"name", "age", "gender"})); err != nil {
+		return err
+	}
+
+	member := &models.CareTeamMember{
+		PatientID:  p.ID,
+		UserID:     doctor.ID,
+		Role:       "attending physician",
+		StartsOn:   time.Now().AddDate(0, 0, -rng.Intn(365)),
+		AssignedBy: sql.NullString{String: receptionist.ID, Valid: true},
+	}
+	if err := store.AddCareTeamMember(ctx, member); err != nil {
+		return err
+	}
+
+	if rng.Intn(10) < 7 {
+		p.Diagnosis = sql.NullString{String: seedDiagnoses[rng.Intn(len(seedDiagnoses))], Valid: true}
+		p.UpdatedBy = doctor.ID
+		if err := store.UpdatePatient(ctx, p, seedAuditEvent(doctor, models.AuditActionPatientUpdate, p.ID, []string{"diagnosis"})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedAuditEvent describes a change made by a seeded staff member for the audit log.
+func seedAuditEvent(actor *models.User, action, patientID string, fields []string) *models.AuditEvent {
+	return &models.AuditEvent{
+		ActorID:   actor.ID,
+		ActorRole: actor.Role,
+		Action:    action,
+		PatientID: patientID,
+		Fields:    fields,
+		Detail:    "seed",
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	auth "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/auth"
+	config "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/config"
+	models "github.com/Faizan2005/Golang_Coding_Assessment_Makerble/models"
+)
+
+// userCommand manages user accounts: "user create", "user disable", "user enable" and "user set-role".
+func userCommand(ctx context.Context, cfg *config.Config, db *sql.DB, store *models.PostgresStore, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, "Usage: app user create|disable|enable|set-role ...\n")
+		return 2
+	}
+
+	switch args[0] {
+	case "create":
+		return createUser(ctx, cfg, store, args[1:])
+	case "disable", "enable":
+		if len(args) != 2 {
+			fmt.Fprintf(os.Stderr, "Usage: app user %s <id or email>\n", args[0])
+			return 2
+		}
+		return setUserDisabled(ctx, store, args[1], args[0] == "disable")
+	case "set-role":
+		if len(args) != 3 {
+			fmt.Fprint(os.Stderr, "Usage: app user set-role <id or email> <role>\n")
+			return 2
+		}
+		return setUserRole(ctx, store, args[1], args[2])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown user command %q; use create, disable, enable or set-role\n", args[0])
+	return 2
+}
+
+// createUser creates an account with any existing role. Without -password a random password is generated
+// and printed, so that the user can log in once and change it.
+func createUser(ctx context.Context, cfg *config.Config, store *models.PostgresStore, args []string) int {
+	flags := flag.NewFlagSet("user create", flag.ContinueOnError)
+	name := flags.String("name", "", "name of the user")
+	email := flags.String("email", "", "email address the user logs in with")
+	role := flags.String("role", "", "role of the user, e.g. receptionist, doctor or admin")
+	password := flags.String("password", "", "initial password; generated when empty")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *name == "" || *email == "" || *role == "" {
+		fmt.Fprint(os.Stderr, "Usage: app user create -name N -email E -role R [-password P]\n")
+		return 2
+	}
+
+	generated := *password == ""
+	if generated {
+		token, _, err := auth.GenerateOpaqueToken()
+		if err != nil {
+			log.Printf("Failed to generate a password: %v", err)
+			return 1
+		}
+		*password = token
+	} else {
+		policy, err := cfg.PasswordPolicy()
+		if err != nil {
+			log.Printf("Failed to load password policy: %v", err)
+			return 1
+		}
+		if err := policy.Validate(*password, *email); err != nil {
+			log.Print(err)
+			return 2
+		}
+	}
+
+	u := models.User{Name: *name, Email: *email, Password: *password, Role: *role}
+	if err := store.CreateUserAccount(ctx, &u); err != nil {
+		log.Printf("Failed to create user: %v", err)
+		return 1
+	}
+
+	fmt.Printf("Created user %s (%s, %s)\n", u.ID, u.Email, u.Role)
+	if generated {
+		fmt.Printf("Password: %s\n", *password)
+	}
+	return 0
+}
+
+// setUserDisabled disables or re-enables the account identified by ref. Disabled accounts can neither log in
+// nor use the tokens they already hold.
+func setUserDisabled(ctx context.Context, store *models.PostgresStore, ref string, disabled bool) int {
+	u, err := findUser(ctx, store, ref)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	if err := store.SetUserDisabled(ctx, u.ID, disabled); err != nil {
+		log.Printf("Failed to update user %s: %v", u.ID, err)
+		return 1
+	}
+
+	state := "enabled"
+	if disabled {
+		state = "disabled"
+	}
+	fmt.Printf("User %s (%s) is %s\n", u.ID, u.Email, state)
+	return 0
+}
+
+// setUserRole changes the role of the account identified by ref.
+func setUserRole(ctx context.Context, store *models.PostgresStore, ref, role string) int {
+	u, err := findUser(ctx, store, ref)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	if err := store.UpdateUserRole(ctx, u.ID, role); err != nil {
+		log.Printf("Failed to update user %s: %v", u.ID, err)
+		return 1
+	}
+
+	fmt.Printf("User %s (%s) now has role %s\n", u.ID, u.Email, role)
+	return 0
+}
+
+// findUser looks up a user by email address when ref contains an @, and by ID otherwise.
+func findUser(ctx context.Context, store *models.PostgresStore, ref string) (*models.User, error) {
+	if strings.Contains(ref, "@") {
+		return store.GetUserByEmail(ctx, ref)
+	}
+	return store.GetUserByID(ctx, ref)
+}